@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// dnsRenewBefore is how long before expiry a DNS-01 certificate is renewed when AutoCert.RenewBefore
+	// is not set, like autocert's default.
+	dnsRenewBefore = 30 * 24 * time.Hour
+	// dnsRenewRetryInterval is how long to wait before retrying a failed renewal.
+	dnsRenewRetryInterval = time.Hour
+	// acmeAccountKeyName is the cache key of the ACME account key, shared with autocert.
+	acmeAccountKeyName = "acme_account+key"
+)
+
+// ErrDNSProviderMissing is returned by RunAutoCertDNS when TLS.AutoCert.DNSProvider is nil.
+var ErrDNSProviderMissing = errors.New("DNS-01 requires TLS.AutoCert.DNSProvider")
+
+// DNSProvider publishes the TXT records of ACME DNS-01 challenges, e.g. through a DNS hosting API.
+type DNSProvider interface {
+	// Present creates a TXT record named "_acme-challenge." + domain with the given value.
+	// For a wildcard such as *.example.com, domain is example.com. The challenge is accepted as soon
+	// as Present returns, so it must only return once the record has propagated to the
+	// authoritative name servers, e.g. by polling them.
+	Present(ctx context.Context, domain, value string) error
+	// CleanUp removes the record created by Present once the challenge is done.
+	CleanUp(ctx context.Context, domain, value string) error
+}
+
+// RunAutoCertDNS starts the HTTPS server with a certificate for all TLS.AutoCert.Domains obtained
+// through the ACME DNS-01 challenge, which supports wildcard domains. The certificate is cached,
+// served from the cache on restart and renewed in the background before it expires.
+func (server *Server) RunAutoCertDNS(ctx context.Context, addr string, httpHandler http.Handler) error {
+	defer server.markStopped()
+
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, stopLifetime := server.withMaxLifetime(ctx)
+	defer stopLifetime()
+
+	if server.TLS.AutoCert == nil {
+		return ErrAutoCertConfigMissing
+	}
+
+	if server.TLS.AutoCert.DNSProvider == nil {
+		return ErrDNSProviderMissing
+	}
+
+	if len(server.TLS.AutoCert.Domains) == 0 {
+		return ErrNoDomains
+	}
+
+	if !validRenewBefore(server.TLS.AutoCert.RenewBefore) {
+		return ErrInvalidRenewBefore
+	}
+
+	tlsConfig, err := server.newTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	server.warnLowWriteTimeout(ctx)
+
+	certificate, err := server.dnsCertificate(ctx)
+	if err != nil {
+		return err
+	}
+
+	listener, err := server.listen(ctx, server.network(), addr)
+	if err != nil {
+		return fmt.Errorf("server error: failed to start TLS server: %w", err)
+	}
+
+	server.certificates.Store(&[]tls.Certificate{*certificate})
+
+	if !hasCertificates(tlsConfig) {
+		tlsConfig.GetCertificate = server.getCertificate
+	}
+
+	go server.renewDNSCertificate(ctx, certificate.Leaf)
+
+	server.startSessionTicketKeyRotation(ctx, tlsConfig)
+
+	httpServer := server.newPrimaryHTTPServer(ctx, addr, httpHandler)
+	httpServer.TLSConfig = tlsConfig
+
+	h3Server := server.newHTTP3Server(ctx)
+
+	err = server.runCancelable(ctx, httpServer, true, func() error {
+		return server.serveTLS(ctx, httpServer, h3Server, listener, "domains", domainsToHTTPSAddress(server.TLS.AutoCert.Domains))
+	})
+
+	err = errors.Join(err, h3Server.shutdown())
+	if err != nil {
+		return fmt.Errorf("server error: %w", err)
+	}
+
+	return nil
+}
+
+// dnsCertificate returns the cached DNS-01 certificate, or obtains a new one if it is missing or due for renewal.
+func (server *Server) dnsCertificate(ctx context.Context) (*tls.Certificate, error) {
+	cache := server.autocertCache(ctx)
+
+	data, err := cache.Get(ctx, server.dnsCertificateCacheKey())
+	if err == nil {
+		certificate, err := parseCachedCertificate(data)
+		// Domains may have changed since the certificate was cached.
+		if err == nil && coversDomains(certificate.Leaf, server.TLS.AutoCert.Domains) &&
+			time.Now().Before(server.dnsRenewalTime(certificate.Leaf)) {
+			server.logger().DebugContext(ctx, "using cached DNS-01 certificate", "notAfter", certificate.Leaf.NotAfter)
+
+			return certificate, nil
+		}
+	} else if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, fmt.Errorf("failed to read certificate from cache: %w", err)
+	}
+
+	return server.obtainDNSCertificate(ctx, cache)
+}
+
+// renewDNSCertificate renews the DNS-01 certificate before it expires until ctx is done.
+func (server *Server) renewDNSCertificate(ctx context.Context, leaf *x509.Certificate) {
+	renewAt := server.dnsRenewalTime(leaf)
+
+	for {
+		timer := time.NewTimer(time.Until(renewAt))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		}
+
+		certificate, err := server.obtainDNSCertificate(ctx, server.autocertCache(ctx))
+		if err != nil {
+			server.logger().ErrorContext(ctx, "failed to renew DNS-01 certificate", "error", err)
+			server.reportError(ctx, fmt.Errorf("failed to renew DNS-01 certificate: %w", err))
+
+			renewAt = time.Now().Add(dnsRenewRetryInterval)
+
+			continue
+		}
+
+		server.certificates.Store(&[]tls.Certificate{*certificate})
+		server.logger().InfoContext(ctx, "DNS-01 certificate renewed", "notAfter", certificate.Leaf.NotAfter)
+
+		renewAt = server.dnsRenewalTime(certificate.Leaf)
+	}
+}
+
+// obtainDNSCertificate orders a certificate for all domains, solves the DNS-01 challenges through
+// the DNS provider and stores the result in cache.
+func (server *Server) obtainDNSCertificate(ctx context.Context, cache autocert.Cache) (*tls.Certificate, error) {
+	prompt, err := server.autocertPrompt()
+	if err != nil {
+		return nil, err
+	}
+
+	accountKey, err := loadOrCreateAccountKey(ctx, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: server.TLS.AutoCert.DirectoryURL,
+	}
+
+	account := &acme.Account{}
+	if server.TLS.AutoCert.Email != "" {
+		account.Contact = []string{"mailto:" + server.TLS.AutoCert.Email}
+	}
+
+	_, err = client.Register(ctx, account, prompt)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register ACME account: %w", err)
+	}
+
+	domains := server.TLS.AutoCert.Domains
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ACME order: %w", err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		err = server.solveDNSChallenge(ctx, client, authzURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for ACME order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize ACME order: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+
+	certificate := &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}
+
+	data, err := encodeCachedCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cache.Put(ctx, server.dnsCertificateCacheKey(), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store certificate in cache: %w", err)
+	}
+
+	return certificate, nil
+}
+
+// solveDNSChallenge presents the DNS-01 record of a pending authorization and waits for it to be valid.
+func (server *Server) solveDNSChallenge(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("failed to get ACME authorization: %w", err)
+	}
+
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			challenge = c
+
+			break
+		}
+	}
+
+	domain := authz.Identifier.Value
+
+	if challenge == nil {
+		return fmt.Errorf("ACME server offered no dns-01 challenge for %s", domain)
+	}
+
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("failed to compute dns-01 record: %w", err)
+	}
+
+	provider := server.TLS.AutoCert.DNSProvider
+
+	err = provider.Present(ctx, domain, value)
+	if err != nil {
+		return fmt.Errorf("failed to present dns-01 record for %s: %w", domain, err)
+	}
+
+	defer func() {
+		err := provider.CleanUp(ctx, domain, value)
+		if err != nil {
+			server.logger().WarnContext(ctx, "failed to clean up dns-01 record", "domain", domain, "error", err)
+		}
+	}()
+
+	_, err = client.Accept(ctx, challenge)
+	if err != nil {
+		return fmt.Errorf("failed to accept dns-01 challenge for %s: %w", domain, err)
+	}
+
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	if err != nil {
+		return fmt.Errorf("failed to authorize %s: %w", domain, err)
+	}
+
+	return nil
+}
+
+// dnsCertificateCacheKey names the cached certificate after the first domain. A wildcard's "*"
+// is replaced because it is not a valid file name on every platform.
+func (server *Server) dnsCertificateCacheKey() string {
+	return strings.ReplaceAll(server.TLS.AutoCert.Domains[0], "*", "_") + "+dns01"
+}
+
+// coversDomains reports whether leaf names every domain, including wildcards, as requested.
+func coversDomains(leaf *x509.Certificate, domains []string) bool {
+	for _, domain := range domains {
+		covered := slices.ContainsFunc(leaf.DNSNames, func(name string) bool {
+			return strings.EqualFold(name, domain)
+		})
+		if !covered {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dnsRenewalTime is AutoCert.RenewBefore ahead of expiry. When it is not set, or not shorter than
+// the lifetime, which would renew the certificate again right away, it is dnsRenewBefore, or a third
+// of the lifetime for short-lived certificates.
+func (server *Server) dnsRenewalTime(leaf *x509.Certificate) time.Time {
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+
+	renewBefore := server.TLS.AutoCert.RenewBefore
+	if renewBefore <= 0 || renewBefore >= lifetime {
+		renewBefore = min(dnsRenewBefore, lifetime/3)
+	}
+
+	return leaf.NotAfter.Add(-renewBefore)
+}
+
+// loadOrCreateAccountKey loads the ACME account key from cache in autocert's format, or creates and stores one.
+func loadOrCreateAccountKey(ctx context.Context, cache autocert.Cache) (crypto.Signer, error) {
+	data, err := cache.Get(ctx, acmeAccountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("failed to decode cached ACME account key")
+		}
+
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cached ACME account key: %w", err)
+		}
+
+		return key, nil
+	}
+
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, fmt.Errorf("failed to read ACME account key from cache: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ACME account key: %w", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ACME account key: %w", err)
+	}
+
+	err = cache.Put(ctx, acmeAccountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store ACME account key in cache: %w", err)
+	}
+
+	return key, nil
+}
+
+// encodeCachedCertificate encodes the private key followed by the chain, like autocert.
+func encodeCachedCertificate(certificate *tls.Certificate) ([]byte, error) {
+	key, ok := certificate.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", certificate.PrivateKey)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate key: %w", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	for _, cert := range certificate.Certificate {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+
+	return data, nil
+}
+
+func parseCachedCertificate(data []byte) (*tls.Certificate, error) {
+	certificate, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cached certificate: %w", err)
+	}
+
+	return &certificate, nil
+}
@@ -0,0 +1,662 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nasermirzaei89/server"
+)
+
+// fakeDNSProvider keeps the presented DNS-01 records in memory.
+type fakeDNSProvider struct {
+	mu       sync.Mutex
+	records  map[string]string
+	presents []string
+}
+
+func (p *fakeDNSProvider) Present(_ context.Context, domain, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.records == nil {
+		p.records = make(map[string]string)
+	}
+
+	p.records[domain] = value
+	p.presents = append(p.presents, domain)
+
+	return nil
+}
+
+func (p *fakeDNSProvider) CleanUp(_ context.Context, domain, _ string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.records, domain)
+
+	return nil
+}
+
+func (p *fakeDNSProvider) lookup(domain string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.records[domain]
+}
+
+type fakeACMEChallenge struct {
+	id     string
+	typ    string
+	token  string
+	status string
+}
+
+type fakeACMEAuthz struct {
+	id         string
+	domain     string
+	wildcard   bool
+	status     string
+	challenges []*fakeACMEChallenge
+}
+
+type fakeACMEOrder struct {
+	id          string
+	identifiers []string
+	authzs      []*fakeACMEAuthz
+	status      string
+	chain       []byte
+}
+
+// fakeACME is a minimal ACME (RFC 8555) server. It does not verify request signatures, but it
+// checks DNS-01 records against the key authorization of the registered account key, and
+// issues certificates from its own CA.
+type fakeACME struct {
+	t        *testing.T
+	server   *httptest.Server
+	ca       *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	lifetime time.Duration
+
+	// validate checks a challenge against the expected key authorization.
+	validate func(challenge *fakeACMEChallenge, authz *fakeACMEAuthz, keyAuth string) bool
+	// unreachable is the number of requests to fail by closing the connection, as if the CA was down.
+	unreachable int
+
+	mu         sync.Mutex
+	nextID     int
+	thumbprint string
+	orders     map[string]*fakeACMEOrder
+	authzs     map[string]*fakeACMEAuthz
+	challenges map[string]*fakeACMEChallenge
+	issued     int
+}
+
+func newFakeACME(t *testing.T) *fakeACME {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+
+	fake := &fakeACME{
+		t:          t,
+		ca:         ca,
+		caKey:      caKey,
+		lifetime:   12 * time.Hour,
+		orders:     make(map[string]*fakeACMEOrder),
+		authzs:     make(map[string]*fakeACMEAuthz),
+		challenges: make(map[string]*fakeACMEChallenge),
+	}
+
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.server.Close)
+
+	return fake
+}
+
+func (f *fakeACME) directoryURL() string {
+	return f.server.URL + "/directory"
+}
+
+func (f *fakeACME) rootCAs() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(f.ca)
+
+	return pool
+}
+
+func (f *fakeACME) issuedCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.issued
+}
+
+func (f *fakeACME) id() string {
+	f.nextID++
+
+	return fmt.Sprint(f.nextID)
+}
+
+func (f *fakeACME) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.unreachable > 0 {
+		f.unreachable--
+
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+
+		return
+	}
+
+	w.Header().Set("Replay-Nonce", "nonce-"+f.id())
+
+	base := f.server.URL
+	path := strings.Trim(r.URL.Path, "/")
+	kind, id, _ := strings.Cut(path, "/")
+
+	if kind == "directory" {
+		f.writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   base + "/nonce",
+			"newAccount": base + "/account",
+			"newOrder":   base + "/new-order",
+			"revokeCert": base + "/revoke",
+			"keyChange":  base + "/key-change",
+		})
+
+		return
+	}
+
+	if kind == "nonce" {
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&jws)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	switch kind {
+	case "account":
+		protected, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+
+		var header struct {
+			JWK json.RawMessage `json:"jwk"`
+		}
+
+		_ = json.Unmarshal(protected, &header)
+
+		sum := sha256.Sum256(header.JWK)
+		f.thumbprint = base64.RawURLEncoding.EncodeToString(sum[:])
+
+		w.Header().Set("Location", base+"/account/1")
+		f.writeJSON(w, http.StatusCreated, map[string]string{"status": "valid"})
+	case "new-order":
+		var request struct {
+			Identifiers []struct {
+				Value string `json:"value"`
+			} `json:"identifiers"`
+		}
+
+		_ = json.Unmarshal(payload, &request)
+
+		order := &fakeACMEOrder{id: f.id(), status: "pending"}
+
+		for _, identifier := range request.Identifiers {
+			domain, wildcard := strings.CutPrefix(identifier.Value, "*.")
+			authz := &fakeACMEAuthz{id: f.id(), domain: domain, wildcard: wildcard, status: "pending"}
+
+			for _, typ := range []string{"http-01", "dns-01"} {
+				challenge := &fakeACMEChallenge{id: f.id(), typ: typ, token: "token" + f.id(), status: "pending"}
+				authz.challenges = append(authz.challenges, challenge)
+				f.challenges[challenge.id] = challenge
+			}
+
+			order.identifiers = append(order.identifiers, identifier.Value)
+			order.authzs = append(order.authzs, authz)
+			f.authzs[authz.id] = authz
+		}
+
+		f.orders[order.id] = order
+
+		w.Header().Set("Location", base+"/order/"+order.id)
+		f.writeJSON(w, http.StatusCreated, f.orderJSON(order))
+	case "order":
+		order := f.orders[id]
+		f.updateOrder(order)
+		f.writeJSON(w, http.StatusOK, f.orderJSON(order))
+	case "authz":
+		f.writeJSON(w, http.StatusOK, f.authzJSON(f.authzs[id]))
+	case "challenge":
+		challenge := f.challenges[id]
+
+		for _, authz := range f.authzs {
+			for _, c := range authz.challenges {
+				if c != challenge {
+					continue
+				}
+
+				challenge.status = "invalid"
+				authz.status = "invalid"
+
+				if f.validate(challenge, authz, challenge.token+"."+f.thumbprint) {
+					challenge.status = "valid"
+					authz.status = "valid"
+				}
+			}
+		}
+
+		f.writeJSON(w, http.StatusOK, f.challengeJSON(challenge))
+	case "finalize":
+		order := f.orders[id]
+
+		var request struct {
+			CSR string `json:"csr"`
+		}
+
+		_ = json.Unmarshal(payload, &request)
+
+		der, _ := base64.RawURLEncoding.DecodeString(request.CSR)
+
+		order.chain, err = f.issue(der)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		order.status = "valid"
+		f.issued++
+
+		w.Header().Set("Location", base+"/order/"+order.id)
+		f.writeJSON(w, http.StatusOK, f.orderJSON(order))
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(f.orders[id].chain)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeACME) updateOrder(order *fakeACMEOrder) {
+	if order.status != "pending" {
+		return
+	}
+
+	for _, authz := range order.authzs {
+		if authz.status != "valid" {
+			return
+		}
+	}
+
+	order.status = "ready"
+}
+
+func (f *fakeACME) issue(csrDER []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(f.lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, f.ca, csr.PublicKey, f.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.ca.Raw})...)
+
+	return chain, nil
+}
+
+func (f *fakeACME) orderJSON(order *fakeACMEOrder) map[string]any {
+	identifiers := make([]map[string]string, 0, len(order.identifiers))
+	for _, value := range order.identifiers {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": value})
+	}
+
+	authorizations := make([]string, 0, len(order.authzs))
+	for _, authz := range order.authzs {
+		authorizations = append(authorizations, f.server.URL+"/authz/"+authz.id)
+	}
+
+	v := map[string]any{
+		"status":         order.status,
+		"identifiers":    identifiers,
+		"authorizations": authorizations,
+		"finalize":       f.server.URL + "/finalize/" + order.id,
+	}
+
+	if order.status == "valid" {
+		v["certificate"] = f.server.URL + "/cert/" + order.id
+	}
+
+	return v
+}
+
+func (f *fakeACME) authzJSON(authz *fakeACMEAuthz) map[string]any {
+	challenges := make([]map[string]any, 0, len(authz.challenges))
+	for _, challenge := range authz.challenges {
+		challenges = append(challenges, f.challengeJSON(challenge))
+	}
+
+	return map[string]any{
+		"identifier": map[string]string{"type": "dns", "value": authz.domain},
+		"status":     authz.status,
+		"wildcard":   authz.wildcard,
+		"challenges": challenges,
+	}
+}
+
+func (f *fakeACME) challengeJSON(challenge *fakeACMEChallenge) map[string]any {
+	v := map[string]any{
+		"type":   challenge.typ,
+		"url":    f.server.URL + "/challenge/" + challenge.id,
+		"token":  challenge.token,
+		"status": challenge.status,
+	}
+
+	if challenge.status == "invalid" {
+		v["error"] = map[string]any{"type": "urn:ietf:params:acme:error:unauthorized", "detail": "challenge failed"}
+	}
+
+	return v
+}
+
+func (f *fakeACME) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		f.t.Errorf("failed to encode response: %v", err)
+	}
+}
+
+// validateHTTP01 accepts http-01 challenges whose token is answered with the key authorization by
+// the challenge server at challengeAddr.
+func validateHTTP01(challengeAddr string) func(*fakeACMEChallenge, *fakeACMEAuthz, string) bool {
+	return func(challenge *fakeACMEChallenge, authz *fakeACMEAuthz, keyAuth string) bool {
+		if challenge.typ != "http-01" {
+			return false
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "http://"+challengeAddr+"/.well-known/acme-challenge/"+challenge.token, nil)
+		if err != nil {
+			return false
+		}
+
+		req.Host = authz.domain
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+
+		return err == nil && resp.StatusCode == http.StatusOK && string(body) == keyAuth
+	}
+}
+
+// validateDNS01 accepts dns-01 challenges whose TXT record, looked up through provider, holds the
+// digest of the key authorization.
+func validateDNS01(provider *fakeDNSProvider) func(*fakeACMEChallenge, *fakeACMEAuthz, string) bool {
+	return func(challenge *fakeACMEChallenge, authz *fakeACMEAuthz, keyAuth string) bool {
+		sum := sha256.Sum256([]byte(keyAuth))
+
+		return challenge.typ == "dns-01" && provider.lookup(authz.domain) == base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+}
+
+func runAutoCertDNS(t *testing.T, srv *server.Server) (string, func()) {
+	t.Helper()
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunAutoCertDNS(ctx, "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		cancel()
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	return addr.String(), func() {
+		cancel()
+
+		err := <-errCh
+		if err != nil {
+			t.Errorf("expected nil error on shutdown, got %v", err)
+		}
+	}
+}
+
+func TestRunAutoCertDNS_ObtainsWildcardCertificate(t *testing.T) {
+	t.Parallel()
+
+	provider := &fakeDNSProvider{}
+	acmeServer := newFakeACME(t)
+	acmeServer.validate = validateDNS01(provider)
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled: true,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:     t.TempDir(),
+				Domains:      []string{"example.com", "*.example.com"},
+				AcceptTOS:    true,
+				DirectoryURL: acmeServer.directoryURL(),
+				DNSProvider:  provider,
+			},
+		},
+	}
+
+	addr, stop := runAutoCertDNS(t, srv)
+	defer stop()
+
+	for _, serverName := range []string{"example.com", "www.example.com"} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: acmeServer.rootCAs(), ServerName: serverName})
+		if err != nil {
+			t.Fatalf("failed to dial %s: %v", serverName, err)
+		}
+
+		_ = conn.Close()
+	}
+
+	if got := len(provider.presents); got != 2 {
+		t.Errorf("expected a record per authorization, got %d", got)
+	}
+
+	if got := provider.lookup("example.com"); got != "" {
+		t.Errorf("expected records to be cleaned up, got %q", got)
+	}
+}
+
+func TestRunAutoCertDNS_UsesCachedCertificate(t *testing.T) {
+	t.Parallel()
+
+	provider := &fakeDNSProvider{}
+	acmeServer := newFakeACME(t)
+	acmeServer.validate = validateDNS01(provider)
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled: true,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:     t.TempDir(),
+				Domains:      []string{"*.example.com"},
+				AcceptTOS:    true,
+				DirectoryURL: acmeServer.directoryURL(),
+				DNSProvider:  provider,
+			},
+		},
+	}
+
+	for range 2 {
+		_, stop := runAutoCertDNS(t, srv)
+		stop()
+	}
+
+	if got := acmeServer.issuedCount(); got != 1 {
+		t.Errorf("expected one issued certificate, got %d", got)
+	}
+}
+
+func TestRunAutoCertDNS_ReplacesCachedCertificateMissingDomains(t *testing.T) {
+	t.Parallel()
+
+	provider := &fakeDNSProvider{}
+	acmeServer := newFakeACME(t)
+	acmeServer.validate = validateDNS01(provider)
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled: true,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:     t.TempDir(),
+				Domains:      []string{"*.example.com"},
+				AcceptTOS:    true,
+				DirectoryURL: acmeServer.directoryURL(),
+				DNSProvider:  provider,
+			},
+		},
+	}
+
+	_, stop := runAutoCertDNS(t, srv)
+	stop()
+
+	srv.TLS.AutoCert.Domains = append(srv.TLS.AutoCert.Domains, "example.org")
+
+	addr, stop := runAutoCertDNS(t, srv)
+	defer stop()
+
+	if got := acmeServer.issuedCount(); got != 2 {
+		t.Errorf("expected a new certificate for the added domain, got %d issued", got)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: acmeServer.rootCAs(), ServerName: "example.org"})
+	if err != nil {
+		t.Fatalf("failed to dial example.org: %v", err)
+	}
+
+	_ = conn.Close()
+}
+
+func TestRunAutoCertDNS_FailsWhenChallengeFails(t *testing.T) {
+	t.Parallel()
+
+	acmeServer := newFakeACME(t)
+	acmeServer.validate = func(*fakeACMEChallenge, *fakeACMEAuthz, string) bool { return false }
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled: true,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:     t.TempDir(),
+				Domains:      []string{"example.com"},
+				AcceptTOS:    true,
+				DirectoryURL: acmeServer.directoryURL(),
+				DNSProvider:  &fakeDNSProvider{},
+			},
+		},
+	}
+
+	err := srv.RunAutoCertDNS(context.Background(), "127.0.0.1:0", http.NewServeMux())
+	if err == nil || !strings.Contains(err.Error(), "failed to authorize example.com") {
+		t.Errorf("expected authorization error, got %v", err)
+	}
+}
+
+func TestRunAutoCertDNS_RequiresDNSProvider(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled: true,
+			AutoCert: &server.ServerTLSAutoCert{
+				Domains:   []string{"example.com"},
+				AcceptTOS: true,
+			},
+		},
+	}
+
+	err := srv.RunAutoCertDNS(context.Background(), "127.0.0.1:0", http.NewServeMux())
+	if !errors.Is(err, server.ErrDNSProviderMissing) {
+		t.Errorf("expected ErrDNSProviderMissing, got %v", err)
+	}
+}
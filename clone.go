@@ -0,0 +1,101 @@
+package server
+
+import (
+	"slices"
+)
+
+// Clone returns a copy of the server's configuration, e.g. to derive an admin server from the main
+// one by changing only its port. Slices, TLS.AutoCert, TLS.Config, ConditionalRequests, Compression,
+// CORS, IPFilter, RateLimit and RequestID are copied, so changing them on the clone does not affect
+// the original. Logger, ErrorLog, HTTP2, ListenConfig, ShutdownBaseContext, the callbacks and
+// AutoCert's HostPolicy, Cache and DNSProvider are shared. Runtime state such as the handler set
+// with SetHandler, Ready and functions registered with RegisterOnShutdown is not copied.
+func (server *Server) Clone() *Server {
+	return &Server{
+		Port:                         server.Port,
+		Host:                         server.Host,
+		Network:                      server.Network,
+		TLS:                          server.TLS.clone(),
+		Logger:                       server.Logger,
+		Timeouts:                     server.Timeouts,
+		ShutdownTimeout:              server.ShutdownTimeout,
+		PreShutdownDelay:             server.PreShutdownDelay,
+		ShutdownBaseContext:          server.ShutdownBaseContext,
+		OnShutdown:                   slices.Clone(server.OnShutdown),
+		EnableH2C:                    server.EnableH2C,
+		HTTP2:                        server.HTTP2,
+		ConfigureHTTPServer:          server.ConfigureHTTPServer,
+		OnReady:                      server.OnReady,
+		ReadinessCheck:               server.ReadinessCheck,
+		MaxHeaderBytes:               server.MaxHeaderBytes,
+		ConnState:                    server.ConnState,
+		KeepAlivePeriod:              server.KeepAlivePeriod,
+		ListenConfig:                 server.ListenConfig,
+		Middleware:                   slices.Clone(server.Middleware),
+		MaxRequestBodyBytes:          server.MaxRequestBodyBytes,
+		ConditionalRequests:          server.ConditionalRequests.clone(),
+		Compression:                  server.Compression.clone(),
+		CORS:                         server.CORS.clone(),
+		RecoverPanics:                server.RecoverPanics,
+		HandlerTimeout:               server.HandlerTimeout,
+		HandlerTimeoutMessage:        server.HandlerTimeoutMessage,
+		TrustedProxies:               slices.Clone(server.TrustedProxies),
+		IPFilter:                     server.IPFilter.clone(),
+		RateLimit:                    server.RateLimit.clone(),
+		AccessLog:                    server.AccessLog,
+		RequestID:                    server.RequestID.clone(),
+		OnError:                      server.OnError,
+		OnTLSHandshakeError:          server.OnTLSHandshakeError,
+		EnableProxyProtocol:          server.EnableProxyProtocol,
+		RequireProxyHeader:           server.RequireProxyHeader,
+		MaxConnections:               server.MaxConnections,
+		MaxLifetime:                  server.MaxLifetime,
+		OnDrain:                      server.OnDrain,
+		OnShutdownComplete:           server.OnShutdownComplete,
+		BaseContext:                  server.BaseContext,
+		ConnContext:                  server.ConnContext,
+		HealthPath:                   server.HealthPath,
+		ReadyPath:                    server.ReadyPath,
+		ErrorLog:                     server.ErrorLog,
+		DisableKeepAlives:            server.DisableKeepAlives,
+		DisableGeneralOptionsHandler: server.DisableGeneralOptionsHandler,
+		StreamingMode:                server.StreamingMode,
+		SlowlorisProtection:          server.SlowlorisProtection,
+		LogLevel:                     server.LogLevel,
+	}
+}
+
+func (serverTLS ServerTLS) clone() ServerTLS {
+	serverTLS.AutoCert = serverTLS.AutoCert.clone()
+	serverTLS.Certificates = slices.Clone(serverTLS.Certificates)
+	serverTLS.CipherSuites = slices.Clone(serverTLS.CipherSuites)
+	serverTLS.CurvePreferences = slices.Clone(serverTLS.CurvePreferences)
+	serverTLS.Config = serverTLS.Config.Clone()
+	serverTLS.SessionTicketKeys = slices.Clone(serverTLS.SessionTicketKeys)
+
+	return serverTLS
+}
+
+func (autoCert *ServerTLSAutoCert) clone() *ServerTLSAutoCert {
+	if autoCert == nil {
+		return nil
+	}
+
+	clone := *autoCert
+	clone.Domains = slices.Clone(autoCert.Domains)
+
+	return &clone
+}
+
+func (config *CORSConfig) clone() *CORSConfig {
+	if config == nil {
+		return nil
+	}
+
+	clone := *config
+	clone.AllowedOrigins = slices.Clone(config.AllowedOrigins)
+	clone.AllowedMethods = slices.Clone(config.AllowedMethods)
+	clone.AllowedHeaders = slices.Clone(config.AllowedHeaders)
+
+	return &clone
+}
@@ -0,0 +1,222 @@
+package server_test
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nasermirzaei89/server"
+)
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.DiscardHandler)
+
+	srv := &server.Server{
+		Port:   "8080",
+		Logger: logger,
+		TLS: server.ServerTLS{
+			Enabled:      true,
+			CipherSuites: []uint16{1, 2},
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir: "certs",
+				Domains:  []string{"example.com"},
+			},
+		},
+		CORS:     &server.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+		Timeouts: server.Timeouts{Write: time.Minute},
+	}
+
+	clone := srv.Clone()
+
+	if clone.Port != "8080" || clone.Timeouts != srv.Timeouts || !clone.TLS.Enabled {
+		t.Errorf("expected clone to copy the configuration, got %+v", clone)
+	}
+
+	if clone.Logger != logger {
+		t.Errorf("expected clone to share the logger")
+	}
+
+	clone.Port = "9090"
+	clone.TLS.AutoCert.CacheDir = "admin-certs"
+	clone.TLS.AutoCert.Domains[0] = "admin.example.com"
+	clone.TLS.CipherSuites[0] = 3
+	clone.CORS.AllowedOrigins[0] = "*"
+
+	if srv.Port != "8080" {
+		t.Errorf("expected port %q, got %q", "8080", srv.Port)
+	}
+
+	if srv.TLS.AutoCert.CacheDir != "certs" {
+		t.Errorf("expected cache dir %q, got %q", "certs", srv.TLS.AutoCert.CacheDir)
+	}
+
+	if !slices.Equal(srv.TLS.AutoCert.Domains, []string{"example.com"}) {
+		t.Errorf("expected domains %v, got %v", []string{"example.com"}, srv.TLS.AutoCert.Domains)
+	}
+
+	if !slices.Equal(srv.TLS.CipherSuites, []uint16{1, 2}) {
+		t.Errorf("expected cipher suites %v, got %v", []uint16{1, 2}, srv.TLS.CipherSuites)
+	}
+
+	if !slices.Equal(srv.CORS.AllowedOrigins, []string{"https://app.example.com"}) {
+		t.Errorf("expected allowed origins %v, got %v", []string{"https://app.example.com"}, srv.CORS.AllowedOrigins)
+	}
+}
+
+func TestClone_NilAutoCert(t *testing.T) {
+	t.Parallel()
+
+	clone := (&server.Server{}).Clone()
+
+	if clone.TLS.AutoCert != nil || clone.CORS != nil || clone.TLS.Config != nil {
+		t.Errorf("expected nil pointers to stay nil, got %+v", clone)
+	}
+}
+
+// cloneExcluded lists the Server fields Clone deliberately leaves out: runtime state, not
+// configuration.
+var cloneExcluded = []string{
+	"mu",
+	"ready",
+	"addr",
+	"httpServer",
+	"baseHTTPServer",
+	"serving",
+	"serveGen",
+	"pendingShutdown",
+	"started",
+	"shutdownFuncs",
+	"connTrackers",
+	"certificates",
+	"inFlight",
+	"handler",
+	"reloadedTimeouts",
+	"draining",
+	"stopCause",
+}
+
+// TestClone_CopiesEveryField sets each Server field, nested ones included, on its own and expects
+// Clone to copy it, so a field added to Server must be copied or listed in cloneExcluded.
+func TestClone_CopiesEveryField(t *testing.T) {
+	t.Parallel()
+
+	serverType := reflect.TypeFor[server.Server]()
+
+	for _, name := range cloneExcluded {
+		_, ok := serverType.FieldByName(name)
+		if !ok {
+			t.Errorf("expected excluded field %s to exist", name)
+		}
+	}
+
+	for _, path := range cloneFieldPaths(t, serverType, nil) {
+		name := cloneFieldName(serverType, path)
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{}
+			field := reflect.ValueOf(srv).Elem().FieldByIndex(path)
+			field.Set(nonZeroValue(t, field.Type()))
+
+			clone := srv.Clone()
+
+			if reflect.ValueOf(clone).Elem().FieldByIndex(path).IsZero() {
+				t.Errorf("expected Clone to copy %s or cloneExcluded to list it", name)
+			}
+		})
+	}
+}
+
+// cloneFieldPaths returns the index paths of the fields of typ Clone is expected to copy,
+// descending into struct fields. Unexported fields must be listed in cloneExcluded.
+func cloneFieldPaths(t *testing.T, typ reflect.Type, prefix []int) [][]int {
+	t.Helper()
+
+	var paths [][]int
+
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		path := append(slices.Clone(prefix), i)
+
+		if slices.Contains(cloneExcluded, field.Name) && len(prefix) == 0 {
+			continue
+		}
+
+		if !field.IsExported() {
+			t.Errorf("expected unexported field %s to be listed in cloneExcluded", field.Name)
+
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			paths = append(paths, cloneFieldPaths(t, field.Type, path)...)
+
+			continue
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+// cloneFieldName returns the dotted name of the field of typ at path, e.g. TLS.Enabled.
+func cloneFieldName(typ reflect.Type, path []int) string {
+	names := make([]string, 0, len(path))
+
+	for _, i := range path {
+		field := typ.Field(i)
+		names = append(names, field.Name)
+		typ = field.Type
+	}
+
+	return strings.Join(names, ".")
+}
+
+// nonZeroValue returns a value of typ that is not its zero value.
+func nonZeroValue(t *testing.T, typ reflect.Type) reflect.Value {
+	t.Helper()
+
+	value := reflect.New(typ).Elem()
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		value.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		value.SetFloat(1)
+	case reflect.String:
+		value.SetString("x")
+	case reflect.Slice:
+		value.Set(reflect.MakeSlice(typ, 1, 1))
+	case reflect.Map:
+		value.Set(reflect.MakeMap(typ))
+	case reflect.Pointer:
+		value.Set(reflect.New(typ.Elem()))
+	case reflect.Func:
+		value.Set(reflect.MakeFunc(typ, func([]reflect.Value) []reflect.Value {
+			return nil
+		}))
+	case reflect.Interface:
+		ctx := reflect.ValueOf(context.Background())
+		if !ctx.Type().Implements(typ) {
+			t.Fatalf("expected a non-zero value for %s", typ)
+		}
+
+		value.Set(ctx)
+	default:
+		t.Fatalf("expected a non-zero value for %s", typ)
+	}
+
+	return value
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressionMinSize is the smallest response body compressed when CompressionConfig.MinSize is not set.
+const DefaultCompressionMinSize = 1024
+
+var defaultCompressionTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// CompressionConfig configures gzip compression of responses.
+type CompressionConfig struct {
+	// MinSize is the smallest response body, in bytes, that is compressed; smaller bodies are not worth
+	// the overhead. Defaults to DefaultCompressionMinSize.
+	MinSize int
+	// Level is the gzip level, from gzip.BestSpeed to gzip.BestCompression. Zero or an invalid level
+	// uses gzip.DefaultCompression.
+	Level int
+	// Types are the media types compressed, such as "application/json", or "text/*" for a whole type.
+	// Defaults to text, JSON, JavaScript, XML and SVG.
+	Types []string
+}
+
+// compressHandler gzips responses of qualifying type and size for clients that accept gzip.
+// The decision is made once MinSize bytes are written, or on the first flush for streamed responses.
+func (server *Server) compressHandler(next http.Handler) http.Handler {
+	config := *server.Compression
+
+	if config.MinSize <= 0 {
+		config.MinSize = DefaultCompressionMinSize
+	}
+
+	if config.Level == 0 || config.Level < gzip.HuffmanOnly || config.Level > gzip.BestCompression {
+		config.Level = gzip.DefaultCompression
+	}
+
+	if len(config.Types) == 0 {
+		config.Types = defaultCompressionTypes
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, config: &config}
+
+		// Not deferred: after a panic, nothing buffered is sent, so RecoverPanics can still answer 500.
+		next.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of r allows gzip, explicitly or through "*".
+func acceptsGzip(r *http.Request) bool {
+	gzipAccepted, anyAccepted := false, false
+	gzipListed := false
+
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for coding := range strings.SplitSeq(header, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "gzip":
+				gzipListed = true
+				gzipAccepted = acceptable(params)
+			case "*":
+				anyAccepted = acceptable(params)
+			}
+		}
+	}
+
+	if gzipListed {
+		return gzipAccepted
+	}
+
+	return anyAccepted
+}
+
+// acceptable reports whether the parameters of an Accept-Encoding entry have a non-zero weight.
+func acceptable(params string) bool {
+	q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+	if !ok {
+		return true
+	}
+
+	weight, err := strconv.ParseFloat(q, 64)
+
+	return err != nil || weight > 0
+}
+
+// compressWriter buffers the start of a response until it knows whether to gzip it.
+// It keeps http.Flusher and http.Hijacker available and supports http.ResponseController via Unwrap.
+type compressWriter struct {
+	http.ResponseWriter
+
+	config  *CompressionConfig
+	status  int
+	buf     []byte
+	decided bool
+	gzip    *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	// Informational responses are sent right away; the final status waits for the decision.
+	if code < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(code)
+
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.decided {
+		if cw.gzip != nil {
+			return cw.gzip.Write(b)
+		}
+
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+
+	if len(cw.buf) >= cw.config.MinSize {
+		err := cw.decide(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		// A streamed response is compressed by type alone, as its size is unknown.
+		_ = cw.decide(true)
+	}
+
+	if cw.gzip != nil {
+		_ = cw.gzip.Flush()
+	}
+
+	flusher, ok := cw.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", cw.ResponseWriter)
+	}
+
+	cw.decided = true
+
+	return hijacker.Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close sends a response that stayed below MinSize uncompressed and finishes the gzip stream.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		_ = cw.decide(false)
+	}
+
+	if cw.gzip != nil {
+		_ = cw.gzip.Close()
+	}
+}
+
+// decide writes the header, compressed if large is set and the response qualifies, and the buffered body.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+
+	header := cw.Header()
+
+	if large && cw.compressible(header) {
+		if header.Get("Content-Type") == "" {
+			// The body is sniffed before it is compressed, as the http package would sniff gzip data.
+			header.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+
+		// The level is valid, so NewWriterLevel cannot fail.
+		cw.gzip, _ = gzip.NewWriterLevel(cw.ResponseWriter, cw.config.Level)
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+
+	var err error
+	if cw.gzip != nil {
+		_, err = cw.gzip.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+// compressible reports whether the response status, encoding and type allow compressing it.
+func (cw *compressWriter) compressible(header http.Header) bool {
+	switch cw.status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(cw.buf)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(cw.config.Types, func(pattern string) bool {
+		prefix, ok := strings.CutSuffix(pattern, "/*")
+		if ok {
+			return strings.HasPrefix(mediaType, prefix+"/")
+		}
+
+		return mediaType == pattern
+	})
+}
+
+func (config *CompressionConfig) clone() *CompressionConfig {
+	if config == nil {
+		return nil
+	}
+
+	clone := *config
+	clone.Types = slices.Clone(config.Types)
+
+	return &clone
+}
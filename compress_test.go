@@ -0,0 +1,189 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	largeJSON := `{"items":[` + strings.Repeat(`{"name":"item"},`, 200) + `{}]}`
+
+	tests := []struct {
+		name           string
+		config         CompressionConfig
+		acceptEncoding string
+		contentType    string
+		body           string
+		expectedGzip   bool
+	}{
+		{
+			name:           "large JSON",
+			acceptEncoding: "gzip, deflate, br",
+			contentType:    "application/json; charset=utf-8",
+			body:           largeJSON,
+			expectedGzip:   true,
+		},
+		{
+			name:           "small JSON",
+			acceptEncoding: "gzip",
+			contentType:    "application/json",
+			body:           `{"ok":true}`,
+		},
+		{
+			name:        "client without gzip",
+			contentType: "application/json",
+			body:        largeJSON,
+		},
+		{
+			name:           "gzip refused",
+			acceptEncoding: "gzip;q=0, *",
+			contentType:    "application/json",
+			body:           largeJSON,
+		},
+		{
+			name:           "gzip through wildcard",
+			acceptEncoding: "*",
+			contentType:    "application/json",
+			body:           largeJSON,
+			expectedGzip:   true,
+		},
+		{
+			name:           "type not configured",
+			acceptEncoding: "gzip",
+			contentType:    "image/png",
+			body:           largeJSON,
+		},
+		{
+			name:           "sniffed type",
+			acceptEncoding: "gzip",
+			body:           strings.Repeat("plain text ", 200),
+			expectedGzip:   true,
+		},
+		{
+			name:           "custom min size and types",
+			config:         CompressionConfig{MinSize: 8, Types: []string{"application/*"}},
+			acceptEncoding: "gzip",
+			contentType:    "application/json",
+			body:           `{"ok":true}`,
+			expectedGzip:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{Compression: &tt.config}
+
+			handler := srv.compressHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+
+				w.WriteHeader(http.StatusCreated)
+
+				// Written in pieces, as encoders do.
+				for chunk := range strings.SplitSeq(tt.body, ",") {
+					_, _ = io.WriteString(w, chunk+",")
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Errorf("expected status %d, got %d", http.StatusCreated, rec.Code)
+			}
+
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("expected Vary %q, got %q", "Accept-Encoding", got)
+			}
+
+			gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.expectedGzip {
+				t.Fatalf("expected gzip %v, got %v", tt.expectedGzip, gzipped)
+			}
+
+			body := rec.Body.String()
+
+			if gzipped {
+				reader, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("failed to read gzip stream: %v", err)
+				}
+
+				decompressed, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("failed to decompress body: %v", err)
+				}
+
+				body = string(decompressed)
+			}
+
+			expected := tt.body + ","
+			if body != expected {
+				t.Errorf("expected body of %d bytes, got %d bytes", len(expected), len(body))
+			}
+		})
+	}
+}
+
+func TestCompression_Flush(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{Compression: &CompressionConfig{}}
+
+	flushed := make(chan string, 1)
+
+	handler := srv.compressHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		_, _ = io.WriteString(w, "data: hello\n\n")
+
+		err := http.NewResponseController(w).Flush()
+		if err != nil {
+			t.Errorf("expected flush to be supported, got %v", err)
+		}
+
+		flushed <- w.Header().Get("Content-Encoding")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if !rec.Flushed {
+		t.Error("expected the response to be flushed")
+	}
+
+	if got := <-flushed; got != "gzip" {
+		t.Errorf("expected Content-Encoding %q after flush, got %q", "gzip", got)
+	}
+
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to read gzip stream: %v", err)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decompress body: %v", err)
+	}
+
+	if string(body) != "data: hello\n\n" {
+		t.Errorf("expected body %q, got %q", "data: hello\n\n", body)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultConditionalMaxBodySize is the largest response body buffered for an ETag when
+// ConditionalRequestsConfig.MaxBodySize is not set.
+const DefaultConditionalMaxBodySize = 1 << 20
+
+// ConditionalRequestsConfig configures ETags and conditional GET handling.
+type ConditionalRequestsConfig struct {
+	// MaxBodySize is the largest response body, in bytes, buffered to compute its ETag. Larger and
+	// flushed responses are sent as they are written, without one. Defaults to
+	// DefaultConditionalMaxBodySize.
+	MaxBodySize int
+}
+
+// conditionalHandler answers GET and HEAD requests with 304 Not Modified when their If-None-Match or
+// If-Modified-Since header matches the response. 200 responses to GET get a weak ETag computed from
+// their body unless the handler set one, which requires buffering the body.
+func (server *Server) conditionalHandler(next http.Handler) http.Handler {
+	maxBodySize := server.ConditionalRequests.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultConditionalMaxBodySize
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		cw := &conditionalWriter{ResponseWriter: w, maxBodySize: maxBodySize}
+
+		// Not deferred: after a panic, nothing buffered is sent, so RecoverPanics can still answer 500.
+		next.ServeHTTP(cw, r)
+		cw.finish(r)
+	})
+}
+
+// conditionalWriter buffers a response until it is complete, so its ETag can be computed before the
+// header is sent. It keeps http.Flusher and http.Hijacker available and supports
+// http.ResponseController via Unwrap.
+type conditionalWriter struct {
+	http.ResponseWriter
+
+	maxBodySize int
+	status      int
+	buf         []byte
+	passthrough bool
+}
+
+func (cw *conditionalWriter) WriteHeader(code int) {
+	// Informational responses are sent right away; the final status waits for the body.
+	if code < http.StatusOK || cw.passthrough {
+		cw.ResponseWriter.WriteHeader(code)
+
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *conditionalWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.passthrough && (cw.status != http.StatusOK || len(cw.buf)+len(b) > cw.maxBodySize) {
+		err := cw.startPassthrough()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if cw.passthrough {
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+
+	return len(b), nil
+}
+
+func (cw *conditionalWriter) Flush() {
+	if !cw.passthrough {
+		_ = cw.startPassthrough()
+	}
+
+	flusher, ok := cw.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *conditionalWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", cw.ResponseWriter)
+	}
+
+	cw.passthrough = true
+
+	return hijacker.Hijack()
+}
+
+func (cw *conditionalWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// startPassthrough sends the header and the buffered body; the rest of the response follows unbuffered.
+func (cw *conditionalWriter) startPassthrough() error {
+	cw.passthrough = true
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+
+	_, err := cw.ResponseWriter.Write(buf)
+
+	return err
+}
+
+// finish sends a buffered response, or 304 Not Modified if the request's preconditions match it.
+func (cw *conditionalWriter) finish(r *http.Request) {
+	if cw.passthrough {
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+
+	if cw.status == http.StatusOK {
+		// A HEAD response has no body to compute the ETag of the GET response from.
+		if header.Get("ETag") == "" && r.Method == http.MethodGet {
+			sum := sha256.Sum256(cw.buf)
+			header.Set("ETag", `W/"`+hex.EncodeToString(sum[:16])+`"`)
+		}
+
+		if notModified(r, header) {
+			for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+				header.Del(name)
+			}
+
+			cw.ResponseWriter.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		if header.Get("Content-Length") == "" && r.Method == http.MethodGet {
+			header.Set("Content-Length", strconv.Itoa(len(cw.buf)))
+		}
+	}
+
+	_ = cw.startPassthrough()
+}
+
+// notModified reports whether the response header satisfies the request's If-None-Match or, without
+// it, its If-Modified-Since header, comparing ETags weakly as RFC 9110 requires for them.
+func notModified(r *http.Request, header http.Header) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+
+		for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(ifModifiedSince)
+}
+
+func (config *ConditionalRequestsConfig) clone() *ConditionalRequestsConfig {
+	if config == nil {
+		return nil
+	}
+
+	clone := *config
+
+	return &clone
+}
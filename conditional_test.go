@@ -0,0 +1,170 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	t.Parallel()
+
+	const body = `{"items":[1,2,3]}`
+
+	srv := &Server{ConditionalRequests: &ConditionalRequestsConfig{}}
+
+	handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, body)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	etag := rec.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("expected a weak ETag, got %q", etag)
+	}
+
+	if rec.Code != http.StatusOK || rec.Body.String() != body {
+		t.Fatalf("expected 200 with the body, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	tests := []struct {
+		name           string
+		ifNoneMatch    string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "matching ETag",
+			ifNoneMatch:    etag,
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "matching strong form in a list",
+			ifNoneMatch:    `"other", ` + strings.TrimPrefix(etag, "W/"),
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "mismatching ETag",
+			ifNoneMatch:    `W/"other"`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   body,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/items", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+
+			if rec.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, rec.Body.String())
+			}
+
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("expected ETag %q, got %q", etag, got)
+			}
+		})
+	}
+}
+
+func TestConditionalRequests_IfModifiedSince(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{ConditionalRequests: &ConditionalRequestsConfig{}}
+
+	handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Last-Modified", "Wed, 01 Jan 2025 00:00:00 GMT")
+		_, _ = io.WriteString(w, "report")
+	}))
+
+	tests := []struct {
+		ifModifiedSince string
+		expectedStatus  int
+	}{
+		{ifModifiedSince: "Wed, 01 Jan 2025 00:00:00 GMT", expectedStatus: http.StatusNotModified},
+		{ifModifiedSince: "Tue, 31 Dec 2024 00:00:00 GMT", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/report", nil)
+		req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("expected status %d for If-Modified-Since %s, got %d", tt.expectedStatus, tt.ifModifiedSince, rec.Code)
+		}
+	}
+}
+
+func TestConditionalRequests_SkipsLargeAndFlushedResponses(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+	}{
+		{
+			name: "over max body size",
+			write: func(w http.ResponseWriter) {
+				_, _ = io.WriteString(w, strings.Repeat("a", 10))
+				_, _ = io.WriteString(w, strings.Repeat("a", 10))
+			},
+		},
+		{
+			name: "flushed",
+			write: func(w http.ResponseWriter) {
+				_, _ = io.WriteString(w, "event")
+				_ = http.NewResponseController(w).Flush()
+			},
+		},
+		{
+			name: "not 200",
+			write: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusCreated)
+				_, _ = io.WriteString(w, "created")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{ConditionalRequests: &ConditionalRequestsConfig{MaxBodySize: 16}}
+
+			handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				tt.write(w)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("If-None-Match", "*")
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code == http.StatusNotModified {
+				t.Error("expected the response to be sent as written")
+			}
+
+			if got := rec.Header().Get("ETag"); got != "" {
+				t.Errorf("expected no ETag, got %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures Cross-Origin Resource Sharing for browser clients.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to make cross-origin requests, such as
+	// "https://app.example.com", or "*" for any origin.
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in preflighted requests. Defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in preflighted requests, or "*" for any.
+	AllowedHeaders []string
+	// AllowCredentials lets browsers send cookies and credentials. The allowed origin is then echoed
+	// instead of "*", which browsers reject with credentials.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response. Zero omits the header.
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// corsHandler sets the Access-Control-* headers for allowed origins and answers their preflight
+// requests itself. Requests from other origins are passed to next unchanged.
+func (server *Server) corsHandler(next http.Handler) http.Handler {
+	config := server.CORS
+
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		anyOrigin := slices.Contains(config.AllowedOrigins, "*")
+		if !anyOrigin && !slices.Contains(config.AllowedOrigins, origin) {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if anyOrigin && !config.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if config.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || requestMethod == "" {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+
+		if slices.Contains(methods, requestMethod) {
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+			switch requestHeaders := r.Header.Get("Access-Control-Request-Headers"); {
+			case slices.Contains(config.AllowedHeaders, "*") && requestHeaders != "":
+				header.Set("Access-Control-Allow-Headers", requestHeaders)
+			case len(config.AllowedHeaders) > 0:
+				header.Set("Access-Control-Allow-Headers", strings.Join(config.AllowedHeaders, ", "))
+			}
+
+			if config.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
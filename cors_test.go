@@ -0,0 +1,184 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		config          CORSConfig
+		method          string
+		headers         map[string]string
+		expectedStatus  int
+		expectedHeaders map[string]string
+	}{
+		{
+			name:   "simple request from allowed origin",
+			config: CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://app.example.com",
+			},
+			expectedStatus: http.StatusTeapot,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "",
+				"Access-Control-Allow-Methods":     "",
+			},
+		},
+		{
+			name:   "simple request from other origin",
+			config: CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://evil.example.com",
+			},
+			expectedStatus: http.StatusTeapot,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:           "same-origin request",
+			config:         CORSConfig{AllowedOrigins: []string{"*"}},
+			method:         http.MethodGet,
+			expectedStatus: http.StatusTeapot,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "",
+			},
+		},
+		{
+			name:   "wildcard origin",
+			config: CORSConfig{AllowedOrigins: []string{"*"}},
+			method: http.MethodPost,
+			headers: map[string]string{
+				"Origin": "https://any.example.com",
+			},
+			expectedStatus: http.StatusTeapot,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+		},
+		{
+			name:   "wildcard origin with credentials",
+			config: CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://any.example.com",
+			},
+			expectedStatus: http.StatusTeapot,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://any.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name: "preflight",
+			config: CORSConfig{
+				AllowedOrigins: []string{"https://app.example.com"},
+				AllowedMethods: []string{http.MethodGet, http.MethodPut},
+				AllowedHeaders: []string{"Content-Type", "Authorization"},
+				MaxAge:         10 * time.Minute,
+			},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  http.MethodPut,
+				"Access-Control-Request-Headers": "content-type",
+			},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, PUT",
+				"Access-Control-Allow-Headers": "Content-Type, Authorization",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name: "preflight with any header",
+			config: CORSConfig{
+				AllowedOrigins: []string{"https://app.example.com"},
+				AllowedHeaders: []string{"*"},
+			},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  http.MethodPost,
+				"Access-Control-Request-Headers": "x-request-id",
+			},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Methods": "GET, HEAD, POST",
+				"Access-Control-Allow-Headers": "x-request-id",
+				"Access-Control-Max-Age":       "",
+			},
+		},
+		{
+			name:   "preflight for disallowed method",
+			config: CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": http.MethodDelete,
+			},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+		{
+			name:   "options without preflight",
+			config: CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin": "https://app.example.com",
+			},
+			expectedStatus: http.StatusTeapot,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "https://app.example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{CORS: &tt.config}
+
+			handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+
+			for key, expected := range tt.expectedHeaders {
+				if got := rec.Header().Get(key); got != expected {
+					t.Errorf("expected %s %q, got %q", key, expected, got)
+				}
+			}
+
+			if tt.headers["Origin"] != "" && !slices.Contains(rec.Header().Values("Vary"), "Origin") {
+				t.Errorf("expected Vary: Origin, got %v", rec.Header().Values("Vary"))
+			}
+		})
+	}
+}
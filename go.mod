@@ -2,9 +2,16 @@ module github.com/nasermirzaei89/server
 
 go 1.24.0
 
-require golang.org/x/crypto v0.48.0
+require (
+	github.com/pires/go-proxyproto v0.11.0
+	github.com/quic-go/quic-go v0.59.0
+	golang.org/x/crypto v0.48.0
+	golang.org/x/net v0.49.0
+	golang.org/x/time v0.14.0
+)
 
 require (
-	golang.org/x/net v0.49.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
 	golang.org/x/text v0.34.0 // indirect
 )
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// ErrHTTP3RequiresTCP is returned when TLS.EnableHTTP3 is set but the server does not listen on TCP,
+// e.g. on a unix socket: HTTP/3 is served on the UDP port matching the TCP one.
+var ErrHTTP3RequiresTCP = errors.New("TLS.EnableHTTP3 requires a TCP listener")
+
+// errQUICConnIO is returned by the net.Conn passed to ConnContext for HTTP/3 connections, whose
+// streams are not read or written through it.
+var errQUICConnIO = errors.New("HTTP/3 connections are not read or written through net.Conn")
+
+// altSvcMaxAge is how long, in seconds, clients may remember the Alt-Svc advertisement, as in quic-go.
+const altSvcMaxAge = 2592000
+
+// http3Server serves the primary handler over HTTP/3 (QUIC) next to the TLS http.Server.
+type http3Server struct {
+	server   *Server
+	ctx      context.Context
+	http3    *http3.Server
+	served   chan struct{}
+	stopOnce func() error
+
+	mu      sync.Mutex
+	conn    net.PacketConn
+	stopped bool
+}
+
+// newHTTP3Server returns the HTTP/3 server run with the primary server when TLS.EnableHTTP3 is set, or nil.
+func (server *Server) newHTTP3Server(ctx context.Context) *http3Server {
+	if !server.TLS.EnableHTTP3 {
+		return nil
+	}
+
+	h3Server := &http3Server{
+		server: server,
+		ctx:    ctx,
+		http3:  &http3.Server{MaxHeaderBytes: server.MaxHeaderBytes},
+		served: make(chan struct{}),
+	}
+	h3Server.stopOnce = sync.OnceValue(h3Server.stop)
+
+	return h3Server
+}
+
+// udpNetwork is the network of the HTTP/3 listener. It follows an IPv4/IPv6-only Network.
+func (server *Server) udpNetwork() string {
+	switch server.tcpNetwork() {
+	case NetworkTCP4:
+		return "udp4"
+	case NetworkTCP6:
+		return "udp6"
+	default:
+		return "udp"
+	}
+}
+
+// serve listens on the UDP port of listener and serves httpServer's handler with its TLS config over
+// HTTP/3, with request contexts derived like httpServer's. httpServer advertises it with an Alt-Svc
+// header and shuts it down along with itself. It must be called before httpServer serves listener.
+func (h3Server *http3Server) serve(httpServer *http.Server, listener net.Listener) error {
+	if h3Server == nil {
+		return nil
+	}
+
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return ErrHTTP3RequiresTCP
+	}
+
+	h3Server.mu.Lock()
+	defer h3Server.mu.Unlock()
+
+	if h3Server.stopped {
+		return nil
+	}
+
+	server := h3Server.server
+	udpAddr := &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+
+	conn, err := server.listenConfig().ListenPacket(h3Server.ctx, server.udpNetwork(), udpAddr.String())
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP/3 server: %w", err)
+	}
+
+	h3Server.conn = conn
+
+	h3Server.http3.Handler = httpServer.Handler
+	h3Server.http3.TLSConfig = httpServer.TLSConfig
+	h3Server.http3.IdleTimeout = httpServer.IdleTimeout
+	h3Server.http3.ConnContext = connContext(httpServer, listener)
+
+	httpServer.Handler = altSvcHandler(httpServer.Handler, addr.Port)
+	httpServer.RegisterOnShutdown(func() { _ = h3Server.shutdown() })
+
+	go func() {
+		defer close(h3Server.served)
+
+		err := h3Server.http3.Serve(conn)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			err = fmt.Errorf("HTTP/3 server error: %w", err)
+			server.logger().ErrorContext(h3Server.ctx, "HTTP/3 server stopped", "error", err)
+			server.reportError(h3Server.ctx, err)
+		}
+	}()
+
+	server.logLifecycle(h3Server.ctx, "HTTP/3 listening on "+conn.LocalAddr().String())
+
+	return nil
+}
+
+// shutdown gracefully shuts the HTTP/3 server down with the shutdown timeout. It is safe to call
+// more than once and concurrently; all calls return the result of the first.
+func (h3Server *http3Server) shutdown() error {
+	if h3Server == nil {
+		return nil
+	}
+
+	return h3Server.stopOnce()
+}
+
+func (h3Server *http3Server) stop() error {
+	h3Server.mu.Lock()
+	h3Server.stopped = true
+	conn := h3Server.conn
+	h3Server.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	shutdownCtx, cancel := h3Server.server.shutdownContext(h3Server.ctx)
+	defer cancel()
+
+	err := h3Server.http3.Shutdown(shutdownCtx)
+	<-h3Server.served
+
+	// http3.Server does not close the connection it was given.
+	closeErr := conn.Close()
+	if err == nil && closeErr != nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("error shutting down HTTP/3 server: %w", err)
+	}
+
+	return nil
+}
+
+// connContext derives the context of each HTTP/3 connection like httpServer does for the ones on
+// listener: from its base context, canceled with the connection, then passed to its ConnContext.
+func connContext(httpServer *http.Server, listener net.Listener) func(context.Context, *quic.Conn) context.Context {
+	baseCtx := httpServer.BaseContext(listener)
+	next := httpServer.ConnContext
+
+	return func(quicCtx context.Context, conn *quic.Conn) context.Context {
+		ctx := baseCtx
+
+		// Keep the values http3.Server set on the connection context.
+		for _, key := range []any{http3.ServerContextKey, http.LocalAddrContextKey, http3.RemoteAddrContextKey} {
+			ctx = context.WithValue(ctx, key, quicCtx.Value(key))
+		}
+
+		ctx, cancel := context.WithCancelCause(ctx)
+		context.AfterFunc(quicCtx, func() { cancel(context.Cause(quicCtx)) })
+
+		if next != nil {
+			ctx = next(ctx, quicConn{conn: conn})
+		}
+
+		return ctx
+	}
+}
+
+// quicConn presents a QUIC connection as the net.Conn ConnContext takes. It only reports the
+// addresses and closes the connection; its streams are not read or written through it.
+type quicConn struct {
+	conn *quic.Conn
+}
+
+func (qc quicConn) Read([]byte) (int, error) {
+	return 0, errQUICConnIO
+}
+
+func (qc quicConn) Write([]byte) (int, error) {
+	return 0, errQUICConnIO
+}
+
+func (qc quicConn) Close() error {
+	return qc.conn.CloseWithError(0, "")
+}
+
+func (qc quicConn) LocalAddr() net.Addr {
+	return qc.conn.LocalAddr()
+}
+
+func (qc quicConn) RemoteAddr() net.Addr {
+	return qc.conn.RemoteAddr()
+}
+
+func (qc quicConn) SetDeadline(time.Time) error {
+	return errQUICConnIO
+}
+
+func (qc quicConn) SetReadDeadline(time.Time) error {
+	return errQUICConnIO
+}
+
+func (qc quicConn) SetWriteDeadline(time.Time) error {
+	return errQUICConnIO
+}
+
+// altSvcHandler advertises HTTP/3 on port with an Alt-Svc header, like http3.Server.SetQUICHeaders
+// but without waiting for the QUIC listener to be registered.
+func altSvcHandler(next http.Handler, port int) http.Handler {
+	altSvc := fmt.Sprintf("%s=\":%d\"; ma=%d", http3.NextProtoH3, port, altSvcMaxAge)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Alt-Svc", altSvc)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,209 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/nasermirzaei89/server"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestRunManualTLS_HTTP3(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:     true,
+			Mode:        server.TLSModeManual,
+			CertFile:    certFile,
+			KeyFile:     keyFile,
+			EnableHTTP3: true,
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.Proto)
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	port := addr.(*net.TCPAddr).Port
+
+	tcpClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		ForceAttemptHTTP2: true,
+	}}
+
+	resp, err := tcpClient.Get("https://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to get over TCP: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	wantAltSvc := fmt.Sprintf("h3=\":%d\"; ma=2592000", port)
+	if got := resp.Header.Get("Alt-Svc"); got != wantAltSvc {
+		t.Errorf("expected Alt-Svc %q, got %q", wantAltSvc, got)
+	}
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13}}
+	defer transport.Close()
+
+	h3Client := &http.Client{Transport: transport}
+
+	resp, err = h3Client.Get(fmt.Sprintf("https://127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("failed to get over HTTP/3: %v", err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if string(body) != "HTTP/3.0" {
+		t.Errorf("expected protocol %q, got %q", "HTTP/3.0", body)
+	}
+
+	if got := resp.Header.Get("Alt-Svc"); got != "" {
+		t.Errorf("expected no Alt-Svc on HTTP/3 responses, got %q", got)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// The UDP port is released once Run returns.
+	conn, err := net.ListenPacket("udp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("expected HTTP/3 port to be released, got %v", err)
+	}
+
+	_ = conn.Close()
+}
+
+func TestValidate_HTTP3RequiresTCP(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Network: server.NetworkUnix,
+		TLS: server.ServerTLS{
+			Enabled:     true,
+			Mode:        server.TLSModeManual,
+			CertFile:    "cert.pem",
+			KeyFile:     "key.pem",
+			EnableHTTP3: true,
+		},
+	}
+
+	err := srv.Validate()
+	if !errors.Is(err, server.ErrHTTP3RequiresTCP) {
+		t.Errorf("expected %v, got %v", server.ErrHTTP3RequiresTCP, err)
+	}
+}
+
+func TestRunManualTLS_HTTP3Context(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	type baseKey struct{}
+
+	type connKey struct{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:     true,
+			Mode:        server.TLSModeManual,
+			CertFile:    certFile,
+			KeyFile:     keyFile,
+			EnableHTTP3: true,
+		},
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(ctx, baseKey{}, "base")
+		},
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, conn.RemoteAddr().Network())
+		},
+	}
+
+	ready := srv.Ready()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			base, _ := r.Context().Value(baseKey{}).(string)
+			network, _ := r.Context().Value(connKey{}).(string)
+			_, fromCtx := server.FromContext(r.Context())
+
+			_, _ = fmt.Fprintf(w, "base=%s conn=%s fromctx=%t", base, network, fromCtx)
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13}}
+	defer transport.Close()
+
+	h3Client := &http.Client{Transport: transport}
+
+	resp, err := h3Client.Get(fmt.Sprintf("https://127.0.0.1:%d", addr.(*net.TCPAddr).Port))
+	if err != nil {
+		t.Fatalf("failed to get over HTTP/3: %v", err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	want := "base=base conn=udp fromctx=true"
+	if string(body) != want {
+		t.Errorf("expected %q, got %q", want, body)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+}
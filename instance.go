@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// errStoppedBeforeReady is returned by Start when Run returns without error before binding.
+var errStoppedBeforeReady = errors.New("server stopped before it was ready")
+
+// Instance is a server started in the background by Start.
+type Instance struct {
+	server *Server
+	addr   net.Addr
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Start runs the server in the background and returns once it is listening, or with the error
+// that kept it from binding. Canceling ctx shuts it down like Run. Start consumes the value sent
+// on the Ready channel, so use Instance.Addr instead.
+func (server *Server) Start(ctx context.Context, httpHandler http.Handler) (*Instance, error) {
+	ready := server.Ready()
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	instance := &Instance{
+		server: server,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(instance.done)
+
+		instance.err = server.Run(ctx, httpHandler)
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		cancel()
+		<-instance.done
+
+		if instance.err == nil {
+			return nil, errStoppedBeforeReady
+		}
+
+		return nil, instance.err
+	}
+
+	instance.addr = addr
+
+	return instance, nil
+}
+
+// Addr returns the address the server is listening on.
+func (instance *Instance) Addr() net.Addr {
+	return instance.addr
+}
+
+// Wait blocks until the server stops and returns the error Run returned.
+func (instance *Instance) Wait() error {
+	<-instance.done
+
+	return instance.err
+}
+
+// Shutdown stops the server gracefully and waits for it, like canceling the context passed to Start.
+// The drain is bounded by the server's shutdown timeout; ctx only bounds how long Shutdown waits.
+func (instance *Instance) Shutdown(ctx context.Context) error {
+	instance.cancel()
+
+	select {
+	case <-instance.done:
+		return instance.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serveRun is the run of a server started with Serve, stopped by Shutdown.
+type serveRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Serve runs the server like Run, but with context.Background(), so it only stops when Shutdown is
+// called or it fails. Use it when the lifecycle is managed with explicit Shutdown calls rather
+// than by canceling a context.
+func (server *Server) Serve(httpHandler http.Handler) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	run := &serveRun{cancel: cancel, done: make(chan struct{})}
+
+	server.mu.Lock()
+
+	server.serveGen++
+	server.started = true
+
+	// Shutdown was called for this Serve before it got here.
+	if server.pendingShutdown == server.serveGen {
+		server.pendingShutdown = 0
+		server.mu.Unlock()
+
+		return nil
+	}
+
+	server.serving = run
+	server.mu.Unlock()
+
+	run.err = server.Run(ctx, httpHandler)
+
+	server.mu.Lock()
+	if server.serving == run {
+		server.serving = nil
+	}
+
+	server.pendingShutdown = 0
+	server.mu.Unlock()
+
+	close(run.done)
+
+	return run.err
+}
+
+// Shutdown stops the server started with Serve gracefully and waits for Serve to return, with the
+// error it returned. The drain is bounded by the server's shutdown timeout; ctx only bounds how
+// long Shutdown waits. On a server that has never run, e.g. right after the first go Serve,
+// Shutdown returns nil and stops that Serve before it binds. Otherwise it returns nil when Serve is
+// not running, so wait for Ready before calling it on a server that ran before. It has no effect
+// on a server started with Run.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	run := server.serving
+
+	// The first Serve may not have registered yet; it claims this generation when it does.
+	if run == nil && !server.started {
+		server.pendingShutdown = server.serveGen + 1
+	}
+	server.mu.Unlock()
+
+	if run == nil {
+		return nil
+	}
+
+	run.cancel()
+
+	select {
+	case <-run.done:
+		return run.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,394 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nasermirzaei89/server"
+)
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	instance, err := srv.Start(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	resp, err := http.Get("http://" + instance.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if got := string(body); got != "ok" {
+		t.Errorf("expected %q, got %q", "ok", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = instance.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	err = instance.Wait()
+	if err != nil {
+		t.Errorf("expected nil from Wait, got %v", err)
+	}
+
+	_, err = http.Get("http://" + instance.Addr().String())
+	if err == nil {
+		t.Error("expected server to be stopped")
+	}
+}
+
+func TestStart_ReturnsBindError(t *testing.T) {
+	t.Parallel()
+
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+
+	_, port, _ := net.SplitHostPort(occupied.Addr().String())
+
+	srv := &server.Server{Host: "127.0.0.1", Port: port}
+
+	instance, err := srv.Start(context.Background(), http.NewServeMux())
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if instance != nil {
+		t.Errorf("expected nil instance, got %v", instance)
+	}
+}
+
+func TestStart_StopsWithContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	instance, err := srv.Start(ctx, http.NewServeMux())
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	cancel()
+
+	err = instance.Wait()
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestInstance_Reload(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	instance, err := srv.Start(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+
+		_, _ = w.Write([]byte("ok"))
+	}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	t.Cleanup(func() { _ = instance.Shutdown(context.Background()) })
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	get := func() error {
+		resp, err := client.Get("http://" + instance.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		_, err = io.ReadAll(resp.Body)
+
+		return err
+	}
+
+	err = get()
+	if err != nil {
+		t.Fatalf("expected the request to succeed before reload, got %v", err)
+	}
+
+	cfg := srv.Clone()
+	cfg.Timeouts.Write = 100 * time.Millisecond
+
+	err = instance.Reload(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+
+	if srv.Timeouts.Write != 100*time.Millisecond {
+		t.Errorf("expected write timeout %v, got %v", 100*time.Millisecond, srv.Timeouts.Write)
+	}
+
+	err = get()
+	if err == nil {
+		t.Error("expected the reloaded write timeout to cut off the slow response")
+	}
+}
+
+func TestInstance_ReloadRejectsPortChange(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	instance, err := srv.Start(context.Background(), http.NewServeMux())
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	t.Cleanup(func() { _ = instance.Shutdown(context.Background()) })
+
+	cfg := srv.Clone()
+	cfg.Port = "9090"
+	cfg.Timeouts.Write = time.Second
+
+	err = instance.Reload(context.Background(), cfg)
+	if !errors.Is(err, server.ErrReloadRequiresRestart) {
+		t.Fatalf("expected %v, got %v", server.ErrReloadRequiresRestart, err)
+	}
+
+	if !strings.Contains(err.Error(), "Port") {
+		t.Errorf("expected the error to name the changed field, got %q", err)
+	}
+
+	if srv.Timeouts.Write != 0 {
+		t.Errorf("expected no change to be applied, got write timeout %v", srv.Timeouts.Write)
+	}
+}
+
+func TestInstance_ReloadRejectsFixedTimeoutChanges(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		field  string
+		change func(cfg *server.Server)
+	}{
+		{name: "read header timeout", field: "Timeouts.ReadHeader", change: func(cfg *server.Server) { cfg.Timeouts.ReadHeader = time.Second }},
+		{name: "idle timeout", field: "Timeouts.Idle", change: func(cfg *server.Server) { cfg.Timeouts.Idle = time.Second }},
+		{name: "shutdown timeout", field: "ShutdownTimeout", change: func(cfg *server.Server) { cfg.ShutdownTimeout = time.Second }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+			instance, err := srv.Start(context.Background(), http.NewServeMux())
+			if err != nil {
+				t.Fatalf("failed to start server: %v", err)
+			}
+
+			t.Cleanup(func() { _ = instance.Shutdown(context.Background()) })
+
+			cfg := srv.Clone()
+			cfg.Timeouts.Write = time.Second
+			tt.change(cfg)
+
+			err = instance.Reload(context.Background(), cfg)
+			if !errors.Is(err, server.ErrReloadRequiresRestart) {
+				t.Fatalf("expected %v, got %v", server.ErrReloadRequiresRestart, err)
+			}
+
+			if !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("expected the error to name %s, got %q", tt.field, err)
+			}
+
+			if srv.Timeouts.Write != 0 {
+				t.Errorf("expected no change to be applied, got write timeout %v", srv.Timeouts.Write)
+			}
+		})
+	}
+}
+
+func TestServe_RunsUntilShutdown(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	ready := srv.Ready()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Serve(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("expected bound address, got closed channel: %v", <-errCh)
+	}
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("expected Serve to keep running, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	resp, err := http.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil from Serve, got %v", err)
+	}
+
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("expected nil when not serving, got %v", err)
+	}
+}
+
+func TestServe_ShutdownRightAfterStart(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Serve(http.NewServeMux())
+	}()
+
+	err := srv.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("expected nil from Serve, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Serve to return after Shutdown")
+	}
+}
+
+func TestServe_AfterShutdownTwice(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	serve(t, srv)
+
+	for range 2 {
+		err := srv.Shutdown(context.Background())
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+	}
+
+	serve(t, srv)
+
+	err := srv.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestServe_AfterShutdownOfRun(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	_, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	err := srv.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Fatalf("expected nil from Run, got %v", err)
+	}
+
+	serve(t, srv)
+
+	err = srv.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+// serve starts srv with Serve and waits until it is listening.
+func serve(t *testing.T, srv *server.Server) {
+	t.Helper()
+
+	ready := srv.Ready()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Serve(http.NewServeMux())
+	}()
+
+	select {
+	case _, ok := <-ready:
+		if !ok {
+			t.Fatalf("server failed to start: %v", <-errCh)
+		}
+	case err := <-errCh:
+		t.Fatalf("expected Serve to keep running, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// IPFilterConfig restricts which client addresses may reach the handler.
+type IPFilterConfig struct {
+	// Allow, when not empty, lists the only CIDRs, such as "10.0.0.0/8", or single IPs allowed.
+	Allow []string
+	// Deny lists CIDRs or single IPs that are rejected, even when they are in Allow.
+	Deny []string
+	// TrustedProxyDepth is the number of proxies in front of the server that append to
+	// X-Forwarded-For. The client address is then taken from that header, that many entries from its
+	// end, instead of r.RemoteAddr. Zero ignores the header, which clients can forge.
+	TrustedProxyDepth int
+}
+
+// ipFilter is an IPFilterConfig with its lists parsed.
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	depth int
+}
+
+func (config *IPFilterConfig) parse() (*ipFilter, error) {
+	allow, allowErr := parsePrefixes("IPFilter.Allow", config.Allow)
+	deny, denyErr := parsePrefixes("IPFilter.Deny", config.Deny)
+
+	err := errors.Join(allowErr, denyErr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipFilter{allow: allow, deny: deny, depth: config.TrustedProxyDepth}, nil
+}
+
+// parsePrefixes parses CIDRs and single IPs, which match only themselves.
+func parsePrefixes(field string, entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+
+	var errs []error
+
+	for _, entry := range entries {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				errs = append(errs, fmt.Errorf("invalid %s entry %q: %w", field, entry, err))
+
+				continue
+			}
+
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, errors.Join(errs...)
+}
+
+// ipFilterHandler answers 403 to clients whose address is denied or not allowed.
+// An invalid configuration, which Validate reports, rejects every request.
+func (server *Server) ipFilterHandler(next http.Handler) http.Handler {
+	filter, err := server.IPFilter.parse()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil || !filter.allows(filter.clientAddr(r)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientAddr returns the client address of r, or an invalid address if it cannot be determined.
+func (filter *ipFilter) clientAddr(r *http.Request) netip.Addr {
+	if filter.depth > 0 {
+		var hops []string
+
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for hop := range strings.SplitSeq(header, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+
+		// Fewer hops than proxies means the request did not come through all of them.
+		if len(hops) < filter.depth {
+			return netip.Addr{}
+		}
+
+		addr, _ := netip.ParseAddr(hops[len(hops)-filter.depth])
+
+		return addr.Unmap()
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, _ := netip.ParseAddr(host)
+
+	return addr.Unmap()
+}
+
+// allows reports whether addr is in no Deny entry and, if Allow is set, in one of its entries.
+func (filter *ipFilter) allows(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+
+	for _, prefix := range filter.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	if len(filter.allow) == 0 {
+		return true
+	}
+
+	for _, prefix := range filter.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (config *IPFilterConfig) clone() *IPFilterConfig {
+	if config == nil {
+		return nil
+	}
+
+	clone := *config
+	clone.Allow = slices.Clone(config.Allow)
+	clone.Deny = slices.Clone(config.Deny)
+
+	return &clone
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		config         IPFilterConfig
+		remoteAddr     string
+		forwardedFor   []string
+		expectedStatus int
+	}{
+		{
+			name:           "allowed CIDR",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/8"}},
+			remoteAddr:     "10.1.2.3:4321",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "outside allowed CIDRs",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/8"}},
+			remoteAddr:     "192.0.2.1:4321",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "allowed single IP",
+			config:         IPFilterConfig{Allow: []string{"192.0.2.1"}},
+			remoteAddr:     "192.0.2.1:4321",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "denied inside allowed CIDR",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.0.0/16"}},
+			remoteAddr:     "10.1.2.3:4321",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "not denied without allow list",
+			config:         IPFilterConfig{Deny: []string{"10.0.0.0/8"}},
+			remoteAddr:     "192.0.2.1:4321",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "IPv4-mapped IPv6",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/8"}},
+			remoteAddr:     "[::ffff:10.1.2.3]:4321",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "IPv6",
+			config:         IPFilterConfig{Allow: []string{"2001:db8::/32"}},
+			remoteAddr:     "[2001:db8::1]:4321",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "forwarded header ignored without trusted proxies",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/8"}},
+			remoteAddr:     "192.0.2.1:4321",
+			forwardedFor:   []string{"10.1.2.3"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "client from forwarded header",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/8"}, TrustedProxyDepth: 1},
+			remoteAddr:     "192.0.2.1:4321",
+			forwardedFor:   []string{"10.1.2.3"},
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "spoofed entry before trusted hops",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/8"}, TrustedProxyDepth: 2},
+			remoteAddr:     "192.0.2.1:4321",
+			forwardedFor:   []string{"10.1.2.3, 198.51.100.7", "192.0.2.10"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "fewer hops than trusted proxies",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/8"}, TrustedProxyDepth: 2},
+			remoteAddr:     "10.1.2.3:4321",
+			forwardedFor:   []string{"10.1.2.4"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "malformed config rejects everything",
+			config:         IPFilterConfig{Allow: []string{"10.0.0.0/33"}},
+			remoteAddr:     "10.1.2.3:4321",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{IPFilter: &tt.config}
+
+			handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestValidate_IPFilter(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		IPFilter: &IPFilterConfig{
+			Allow: []string{"10.0.0.0/8", "10.0.0.0/33"},
+			Deny:  []string{"not-an-ip"},
+		},
+	}
+
+	err := srv.Validate()
+	if err == nil {
+		t.Fatal("expected an error for malformed entries")
+	}
+
+	for _, expected := range []string{`IPFilter.Allow entry "10.0.0.0/33"`, `IPFilter.Deny entry "not-an-ip"`} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %q", expected, err)
+		}
+	}
+
+	srv.IPFilter.Allow = []string{"10.0.0.0/8", "192.0.2.1"}
+	srv.IPFilter.Deny = nil
+
+	err = srv.Validate()
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
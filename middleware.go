@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"time"
+)
+
+// wrapHandler applies Middleware and the optional middleware configured on the server to the primary handler.
+func (server *Server) wrapHandler(httpHandler http.Handler) http.Handler {
+	for _, middleware := range slices.Backward(server.Middleware) {
+		httpHandler = middleware(httpHandler)
+	}
+
+	if server.MaxRequestBodyBytes > 0 {
+		httpHandler = server.maxBodyHandler(httpHandler)
+	}
+
+	if server.CORS != nil {
+		httpHandler = server.corsHandler(httpHandler)
+	}
+
+	if server.ConditionalRequests != nil {
+		httpHandler = server.conditionalHandler(httpHandler)
+	}
+
+	if server.Compression != nil {
+		httpHandler = server.compressHandler(httpHandler)
+	}
+
+	if server.RecoverPanics {
+		httpHandler = server.recoverHandler(httpHandler)
+	}
+
+	if server.HandlerTimeout > 0 {
+		httpHandler = http.TimeoutHandler(httpHandler, server.HandlerTimeout, server.HandlerTimeoutMessage)
+	}
+
+	if server.RateLimit != nil {
+		httpHandler = server.rateLimitHandler(httpHandler)
+	}
+
+	if server.IPFilter != nil {
+		httpHandler = server.ipFilterHandler(httpHandler)
+	}
+
+	if server.AccessLog {
+		httpHandler = server.accessLogHandler(httpHandler)
+	}
+
+	if server.RequestID != nil {
+		httpHandler = server.requestIDHandler(httpHandler)
+	}
+
+	if len(server.TrustedProxies) > 0 {
+		httpHandler = server.proxyHeadersHandler(httpHandler)
+	}
+
+	httpHandler = server.inFlightHandler(httpHandler)
+
+	if server.HealthPath != "" || server.ReadyPath != "" {
+		httpHandler = server.healthHandler(httpHandler)
+	}
+
+	return httpHandler
+}
+
+// healthHandler answers HealthPath and ReadyPath itself and passes other requests to next.
+// Health is 200 while the server answers at all; readiness turns 503 once shutdown starts.
+func (server *Server) healthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case server.HealthPath != "" && r.URL.Path == server.HealthPath:
+			writeHealth(w, http.StatusOK, "ok")
+		case server.ReadyPath != "" && r.URL.Path == server.ReadyPath:
+			if server.draining.Load() {
+				writeHealth(w, http.StatusServiceUnavailable, "draining")
+
+				return
+			}
+
+			writeHealth(w, http.StatusOK, "ready")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func writeHealth(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+}
+
+// inFlightHandler counts the requests being handled, to report draining progress on shutdown.
+func (server *Server) inFlightHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.inFlight.Add(1)
+		defer server.inFlight.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxBodyHandler rejects requests declaring a body over MaxRequestBodyBytes with 413 and limits the
+// body of the others, so reading past the limit fails with *http.MaxBytesError.
+func (server *Server) maxBodyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > server.MaxRequestBodyBytes {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, server.MaxRequestBodyBytes)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverHandler recovers panics in the handler, logs them and responds with 500.
+// http.ErrAbortHandler is re-panicked so the http package can abort the response as intended.
+func (server *Server) recoverHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(rec)
+			}
+
+			server.logger().ErrorContext(r.Context(), "handler panicked",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", rec,
+				"stack", string(debug.Stack()),
+			)
+
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accessLogHandler logs one line per request with method, path, status, duration and bytes written.
+func (server *Server) accessLogHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r)
+
+		server.logger().InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.statusCode(),
+			"duration", time.Since(start),
+			"bytes", rw.bytes,
+		)
+	})
+}
+
+// responseWriter records the status code and body size written through it.
+// It keeps http.Flusher and http.Hijacker available and supports http.ResponseController via Unwrap.
+type responseWriter struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int64
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	// Informational responses are not final, except for 101 Switching Protocols.
+	if rw.status == 0 && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+		rw.status = code
+	}
+
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	flusher, ok := rw.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	flusher.Flush()
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", rw.ResponseWriter)
+	}
+
+	conn, buf, err := hijacker.Hijack()
+	if err == nil && rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, buf, err
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *responseWriter) statusCode() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+
+	return rw.status
+}
@@ -0,0 +1,524 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordAttrs(record slog.Record) map[string]slog.Value {
+	attrs := map[string]slog.Value{}
+
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value
+
+		return true
+	})
+
+	return attrs
+}
+
+func TestRecoverPanics(t *testing.T) {
+	t.Parallel()
+
+	logs := &captureHandler{}
+
+	srv := &Server{
+		Logger:        slog.New(logs),
+		RecoverPanics: true,
+	}
+
+	httpServer := srv.newPrimaryHTTPServer(context.Background(), ":0", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+
+	recorder := httptest.NewRecorder()
+	httpServer.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/panic", nil))
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, recorder.Code)
+	}
+
+	captured, ok := logs.find("handler panicked")
+	if !ok {
+		t.Fatal("expected panic to be logged")
+	}
+
+	if captured.record.Level != slog.LevelError {
+		t.Errorf("expected level %v, got %v", slog.LevelError, captured.record.Level)
+	}
+
+	attrs := recordAttrs(captured.record)
+
+	expected := map[string]string{"method": http.MethodPost, "path": "/panic", "panic": "boom"}
+	for key, want := range expected {
+		if got := attrs[key].String(); got != want {
+			t.Errorf("expected %s %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestRecoverPanics_Disabled(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{}
+
+	httpServer := srv.newPrimaryHTTPServer(context.Background(), ":0", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic to propagate")
+		}
+	}()
+
+	httpServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestRecoverPanics_RepanicsAbortHandler(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{}
+
+	handler := srv.recoverHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		rec := recover()
+
+		err, ok := rec.(error)
+		if !ok || !errors.Is(err, http.ErrAbortHandler) {
+			t.Errorf("expected %v, got %v", http.ErrAbortHandler, rec)
+		}
+	}()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		expectedStatus int64
+		expectedBytes  int64
+	}{
+		{
+			name: "implicit ok",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("hello"))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBytes:  5,
+		},
+		{
+			name:           "no body",
+			handler:        func(http.ResponseWriter, *http.Request) {},
+			expectedStatus: http.StatusOK,
+			expectedBytes:  0,
+		},
+		{
+			name: "explicit status",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+				_, _ = w.Write([]byte("tea"))
+			},
+			expectedStatus: http.StatusTeapot,
+			expectedBytes:  3,
+		},
+		{
+			name: "informational before final status",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBytes:  0,
+		},
+		{
+			name: "recovered panic",
+			handler: func(http.ResponseWriter, *http.Request) {
+				panic("boom")
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBytes:  int64(len(http.StatusText(http.StatusInternalServerError)) + 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			logs := &captureHandler{}
+
+			srv := &Server{
+				Logger:        slog.New(logs),
+				AccessLog:     true,
+				RecoverPanics: true,
+			}
+
+			handler := srv.wrapHandler(tt.handler)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/path?q=1", nil))
+
+			captured, ok := logs.find("request")
+			if !ok {
+				t.Fatal("expected access log record")
+			}
+
+			attrs := recordAttrs(captured.record)
+
+			if got := attrs["method"].String(); got != http.MethodGet {
+				t.Errorf("expected method %q, got %q", http.MethodGet, got)
+			}
+
+			if got := attrs["path"].String(); got != "/path" {
+				t.Errorf("expected path %q, got %q", "/path", got)
+			}
+
+			if got := attrs["status"].Int64(); got != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, got)
+			}
+
+			if got := attrs["bytes"].Int64(); got != tt.expectedBytes {
+				t.Errorf("expected bytes %d, got %d", tt.expectedBytes, got)
+			}
+
+			if duration, ok := attrs["duration"]; !ok || duration.Kind() != slog.KindDuration {
+				t.Errorf("expected duration attribute, got %v", duration)
+			}
+		})
+	}
+}
+
+func TestAccessLog_PreservesFlusherAndHijacker(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{AccessLog: true}
+
+	testServer := httptest.NewServer(srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("expected http.Flusher")
+		}
+
+		err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Minute))
+		if err != nil {
+			t.Errorf("expected response controller to unwrap, got %v", err)
+		}
+
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("expected http.Hijacker")
+
+			return
+		}
+
+		conn, buf, err := hijacker.Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack: %v", err)
+
+			return
+		}
+		defer conn.Close()
+
+		_, _ = buf.WriteString("HTTP/1.1 204 No Content\r\n\r\n")
+		_ = buf.Flush()
+	})))
+	defer testServer.Close()
+
+	conn, err := net.Dial("tcp", testServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Host:       "127.0.0.1",
+		Port:       "0",
+		HealthPath: "/healthz",
+		ReadyPath:  "/readyz",
+	}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	instance, err := srv.Start(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-release
+		}
+
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	srv.mu.Lock()
+	handler := srv.httpServer.Handler
+	srv.mu.Unlock()
+
+	probe := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return rec.Code
+	}
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{path: "/healthz", expected: http.StatusOK},
+		{path: "/readyz", expected: http.StatusOK},
+		{path: "/other", expected: http.StatusTeapot},
+	}
+
+	for _, tt := range tests {
+		if got := probe(tt.path); got != tt.expected {
+			t.Errorf("expected %s to return %d while serving, got %d", tt.path, tt.expected, got)
+		}
+	}
+
+	go func() {
+		resp, err := http.Get("http://" + instance.Addr().String() + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-entered
+
+	shutdownErr := make(chan error, 1)
+
+	go func() {
+		shutdownErr <- instance.Shutdown(context.Background())
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for probe("/readyz") != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("expected /readyz to return 503 while draining")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := probe("/healthz"); got != http.StatusOK {
+		t.Errorf("expected /healthz to return 200 while draining, got %d", got)
+	}
+
+	close(release)
+
+	err = <-shutdownErr
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestHealthHandler_Disabled(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{}
+
+	handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("expected request to reach the handler, got %d", rec.Code)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		message        string
+		delay          time.Duration
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "fast handler",
+			delay:          0,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "done",
+		},
+		{
+			name:           "slow handler",
+			message:        "request took too long",
+			delay:          time.Second,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "request took too long",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			logs := &captureHandler{}
+
+			srv := &Server{
+				Logger:                slog.New(logs),
+				AccessLog:             true,
+				HandlerTimeout:        50 * time.Millisecond,
+				HandlerTimeoutMessage: tt.message,
+			}
+
+			canceled := make(chan bool, 1)
+
+			handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+					canceled <- false
+				case <-r.Context().Done():
+					canceled <- true
+
+					return
+				}
+
+				_, _ = w.Write([]byte("done"))
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+
+			if got := rec.Body.String(); got != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, got)
+			}
+
+			expectedCanceled := tt.expectedStatus == http.StatusServiceUnavailable
+			if got := <-canceled; got != expectedCanceled {
+				t.Errorf("expected handler context canceled %v, got %v", expectedCanceled, got)
+			}
+
+			captured, ok := logs.find("request")
+			if !ok {
+				t.Fatal("expected access log record")
+			}
+
+			if got := recordAttrs(captured.record)["status"].Int64(); got != int64(tt.expectedStatus) {
+				t.Errorf("expected logged status %d, got %d", tt.expectedStatus, got)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	appendHeader := func(value string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Middleware", value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	srv := &Server{
+		Middleware: []func(http.Handler) http.Handler{
+			appendHeader("first"),
+			appendHeader("second"),
+			appendHeader("third"),
+		},
+	}
+
+	handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Add("X-Middleware", "handler")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := []string{"first", "second", "third", "handler"}
+	if got := rec.Header().Values("X-Middleware"); !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestMaxRequestBodyBytes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		body           string
+		chunked        bool
+		expectedStatus int
+	}{
+		{name: "within limit", body: "12345678", expectedStatus: http.StatusOK},
+		{name: "too large", body: "123456789", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "too large without content length", body: "123456789", chunked: true, expectedStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{MaxRequestBodyBytes: 8}
+
+			handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := io.ReadAll(r.Body)
+
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+
+					return
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// ocspRetryInterval is how long to wait before retrying a failed OCSP fetch.
+	ocspRetryInterval = 5 * time.Minute
+	// ocspDefaultRefreshInterval is used when an OCSP response has no NextUpdate.
+	ocspDefaultRefreshInterval = time.Hour
+	// ocspFetchTimeout bounds a single request to an OCSP responder.
+	ocspFetchTimeout = 10 * time.Second
+	// maxOCSPResponseSize bounds the body read from an OCSP responder.
+	maxOCSPResponseSize = 1 << 20
+)
+
+// ErrNoOCSPServer is returned when stapling a certificate that does not name an OCSP responder.
+var ErrNoOCSPServer = errors.New("certificate has no OCSP server")
+
+// stapleOCSP returns a copy of certificates with fresh OCSP responses stapled and the time the
+// staples should be refreshed. Certificates that cannot be stapled keep their previous staple;
+// failures are logged and passed to OnError.
+func (server *Server) stapleOCSP(ctx context.Context, certificates []tls.Certificate) ([]tls.Certificate, time.Time) {
+	stapled := make([]tls.Certificate, len(certificates))
+	copy(stapled, certificates)
+
+	refreshAt := time.Now().Add(ocspDefaultRefreshInterval)
+
+	for i := range stapled {
+		staple, response, err := fetchOCSPStaple(ctx, &stapled[i])
+		if err != nil {
+			server.logger().ErrorContext(ctx, "failed to staple OCSP response", "error", err)
+			server.reportError(ctx, fmt.Errorf("failed to staple OCSP response: %w", err))
+
+			refreshAt = earliest(refreshAt, time.Now().Add(ocspRetryInterval))
+
+			continue
+		}
+
+		stapled[i].OCSPStaple = staple
+
+		refreshAt = earliest(refreshAt, ocspRefreshTime(response))
+	}
+
+	return stapled, refreshAt
+}
+
+// refreshOCSPStaples keeps the OCSP staples of the manual certificates fresh until ctx is done.
+func (server *Server) refreshOCSPStaples(ctx context.Context, refreshAt time.Time) {
+	for {
+		timer := time.NewTimer(time.Until(refreshAt))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		}
+
+		current := server.certificates.Load()
+		if current == nil {
+			refreshAt = time.Now().Add(ocspRetryInterval)
+
+			continue
+		}
+
+		var stapled []tls.Certificate
+
+		stapled, refreshAt = server.stapleOCSP(ctx, *current)
+
+		// A concurrent ReloadCertificates has stapled its own certificates already.
+		if server.certificates.CompareAndSwap(current, &stapled) {
+			server.logger().DebugContext(ctx, "OCSP staples refreshed")
+		}
+	}
+}
+
+// fetchOCSPStaple requests the OCSP response for the leaf of certificate from the responder it names.
+// The chain must include the issuer right after the leaf.
+func fetchOCSPStaple(ctx context.Context, certificate *tls.Certificate) ([]byte, *ocsp.Response, error) {
+	if len(certificate.Certificate) < 2 {
+		return nil, nil, errors.New("certificate chain has no issuer")
+	}
+
+	leaf := certificate.Leaf
+	if leaf == nil {
+		var err error
+
+		leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNoOCSPServer, leaf.Subject)
+	}
+
+	issuer, err := x509.ParseCertificate(certificate.Certificate[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse issuer certificate: %w", err)
+	}
+
+	request, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ocspFetchTimeout)
+	defer cancel()
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(request))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/ocsp-request")
+
+	httpResponse, err := http.DefaultClient.Do(httpRequest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to request OCSP response: %w", err)
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder returned status %d", httpResponse.StatusCode)
+	}
+
+	staple, err := io.ReadAll(io.LimitReader(httpResponse.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+
+	response, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse OCSP response: %w", err)
+	}
+
+	if response.Status != ocsp.Good {
+		return nil, nil, fmt.Errorf("OCSP status of %s is not good: %d", leaf.Subject, response.Status)
+	}
+
+	return staple, response, nil
+}
+
+// ocspRefreshTime is halfway through the validity of an OCSP response.
+func ocspRefreshTime(response *ocsp.Response) time.Time {
+	if response.NextUpdate.IsZero() {
+		return time.Now().Add(ocspDefaultRefreshInterval)
+	}
+
+	return response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)
+}
+
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+
+	return a
+}
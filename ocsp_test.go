@@ -0,0 +1,268 @@
+package server_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nasermirzaei89/server"
+	"golang.org/x/crypto/ocsp"
+)
+
+// writeOCSPTestChain writes a leaf certificate naming ocspServer as its responder, followed by its CA,
+// and returns the files, the CA pool and the CA with its key for signing OCSP responses.
+func writeOCSPTestChain(t *testing.T, ocspServer string) (string, string, *x509.CertPool, *x509.Certificate, crypto.Signer) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate leaf key: %v", err)
+	}
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		OCSPServer:   []string{ocspServer},
+	}
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create leaf certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(leafKey)
+	if err != nil {
+		t.Fatalf("failed to marshal leaf key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	chain := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...,
+	)
+
+	err = os.WriteFile(certFile, chain, 0o600)
+	if err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return certFile, keyFile, pool, ca, caKey
+}
+
+func TestRunManualTLS_OCSPStapling(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ca    *x509.Certificate
+		caKey crypto.Signer
+	)
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		request, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		response, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: request.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(response)
+	}))
+	defer responder.Close()
+
+	certFile, keyFile, pool, issuer, issuerKey := writeOCSPTestChain(t, responder.URL)
+	ca, caKey = issuer, issuerKey
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:            true,
+			Mode:               server.TLSModeManual,
+			CertFile:           certFile,
+			KeyFile:            keyFile,
+			EnableOCSPStapling: true,
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if len(state.OCSPResponse) == 0 {
+		t.Fatal("expected stapled OCSP response")
+	}
+
+	response, err := ocsp.ParseResponseForCert(state.OCSPResponse, state.PeerCertificates[0], ca)
+	if err != nil {
+		t.Fatalf("failed to parse stapled OCSP response: %v", err)
+	}
+
+	if response.Status != ocsp.Good {
+		t.Errorf("expected status %d, got %d", ocsp.Good, response.Status)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestRunManualTLS_OCSPStaplingFailureIsNotFatal(t *testing.T) {
+	t.Parallel()
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer responder.Close()
+
+	certFile, keyFile, pool, _, _ := writeOCSPTestChain(t, responder.URL)
+
+	errs := make(chan error, 1)
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:            true,
+			Mode:               server.TLSModeManual,
+			CertFile:           certFile,
+			KeyFile:            keyFile,
+			EnableOCSPStapling: true,
+		},
+		OnError: func(_ context.Context, err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	select {
+	case <-errs:
+	default:
+		t.Error("expected stapling failure to be reported")
+	}
+
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if len(conn.ConnectionState().OCSPResponse) != 0 {
+		t.Error("expected no stapled OCSP response")
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"log/slog"
+)
+
+const errIncompatibleTLSOptions = "server: WithAutoCert and WithManualTLS cannot be used together"
+
+// Option configures a Server created by New.
+type Option func(server *Server)
+
+// New creates a Server with defaults applied, then applies the given options in order.
+// It panics if incompatible options are combined, such as WithAutoCert and WithManualTLS.
+func New(opts ...Option) *Server {
+	server := &Server{
+		Port: DefaultPort,
+		TLS: ServerTLS{
+			Mode: DefaultTLSMode,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(server)
+	}
+
+	return server
+}
+
+// WithPort sets the port the server listens on.
+func WithPort(port string) Option {
+	return func(server *Server) {
+		server.Port = port
+	}
+}
+
+// WithHost sets the host the server listens on.
+func WithHost(host string) Option {
+	return func(server *Server) {
+		server.Host = host
+	}
+}
+
+// WithLogger sets the logger used for server lifecycle messages.
+func WithLogger(logger *slog.Logger) Option {
+	return func(server *Server) {
+		server.Logger = logger
+	}
+}
+
+// WithDefaultLogger logs through slog.Default() as it is when the option is applied, instead of
+// discarding output when no logger is set.
+func WithDefaultLogger() Option {
+	return func(server *Server) {
+		server.Logger = slog.Default()
+	}
+}
+
+// WithAutoCert enables TLS with certificates obtained automatically via ACME.
+func WithAutoCert(autoCert ServerTLSAutoCert) Option {
+	return func(server *Server) {
+		if server.TLS.Enabled && server.TLS.Mode == TLSModeManual {
+			panic(errIncompatibleTLSOptions)
+		}
+
+		server.TLS.Enabled = true
+		server.TLS.Mode = TLSModeAutoCert
+		server.TLS.AutoCert = &autoCert
+	}
+}
+
+// WithManualTLS enables TLS with the given certificate and key files.
+func WithManualTLS(certFile, keyFile string) Option {
+	return func(server *Server) {
+		if server.TLS.Enabled && server.TLS.Mode == TLSModeAutoCert {
+			panic(errIncompatibleTLSOptions)
+		}
+
+		server.TLS.Enabled = true
+		server.TLS.Mode = TLSModeManual
+		server.TLS.CertFile = certFile
+		server.TLS.KeyFile = keyFile
+	}
+}
+
+// WithTimeouts sets the timeouts of the underlying http.Server.
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(server *Server) {
+		server.Timeouts = timeouts
+	}
+}
@@ -0,0 +1,166 @@
+package server_test
+
+import (
+	"bytes"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nasermirzaei89/server"
+)
+
+func TestNew_AppliesDefaults(t *testing.T) {
+	t.Parallel()
+
+	srv := server.New()
+
+	if srv.Port != server.DefaultPort {
+		t.Errorf("expected port %q, got %q", server.DefaultPort, srv.Port)
+	}
+
+	if srv.TLS.Mode != server.DefaultTLSMode {
+		t.Errorf("expected TLS mode %q, got %q", server.DefaultTLSMode, srv.TLS.Mode)
+	}
+
+	if srv.TLS.Enabled {
+		t.Error("expected TLS to be disabled")
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.DiscardHandler)
+
+	tests := []struct {
+		name  string
+		opt   server.Option
+		check func(t *testing.T, srv *server.Server)
+	}{
+		{
+			name: "port",
+			opt:  server.WithPort("9090"),
+			check: func(t *testing.T, srv *server.Server) {
+				t.Helper()
+
+				if srv.Port != "9090" {
+					t.Errorf("expected port %q, got %q", "9090", srv.Port)
+				}
+			},
+		},
+		{
+			name: "host",
+			opt:  server.WithHost("127.0.0.1"),
+			check: func(t *testing.T, srv *server.Server) {
+				t.Helper()
+
+				if srv.Host != "127.0.0.1" {
+					t.Errorf("expected host %q, got %q", "127.0.0.1", srv.Host)
+				}
+			},
+		},
+		{
+			name: "logger",
+			opt:  server.WithLogger(logger),
+			check: func(t *testing.T, srv *server.Server) {
+				t.Helper()
+
+				if srv.Logger != logger {
+					t.Error("expected logger to be set")
+				}
+			},
+		},
+		{
+			name: "autocert",
+			opt: server.WithAutoCert(server.ServerTLSAutoCert{
+				CacheDir: "./cert-cache",
+				Domains:  []string{"example.com"},
+				Email:    "ops@example.com",
+			}),
+			check: func(t *testing.T, srv *server.Server) {
+				t.Helper()
+
+				if !srv.TLS.Enabled || srv.TLS.Mode != server.TLSModeAutoCert {
+					t.Errorf("expected autocert TLS, got enabled=%v mode=%q", srv.TLS.Enabled, srv.TLS.Mode)
+				}
+
+				if srv.TLS.AutoCert == nil || !slices.Equal(srv.TLS.AutoCert.Domains, []string{"example.com"}) {
+					t.Errorf("expected autocert domains to be set, got %+v", srv.TLS.AutoCert)
+				}
+			},
+		},
+		{
+			name: "manual TLS",
+			opt:  server.WithManualTLS("cert.pem", "key.pem"),
+			check: func(t *testing.T, srv *server.Server) {
+				t.Helper()
+
+				if !srv.TLS.Enabled || srv.TLS.Mode != server.TLSModeManual {
+					t.Errorf("expected manual TLS, got enabled=%v mode=%q", srv.TLS.Enabled, srv.TLS.Mode)
+				}
+
+				if srv.TLS.CertFile != "cert.pem" || srv.TLS.KeyFile != "key.pem" {
+					t.Errorf("expected cert and key files to be set, got %q and %q", srv.TLS.CertFile, srv.TLS.KeyFile)
+				}
+			},
+		},
+		{
+			name: "timeouts",
+			opt:  server.WithTimeouts(server.Timeouts{Write: time.Minute}),
+			check: func(t *testing.T, srv *server.Server) {
+				t.Helper()
+
+				if srv.Timeouts.Write != time.Minute {
+					t.Errorf("expected write timeout %v, got %v", time.Minute, srv.Timeouts.Write)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.check(t, server.New(tt.opt))
+		})
+	}
+}
+
+func TestNew_PanicsOnIncompatibleTLSOptions(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic, got none")
+		}
+	}()
+
+	server.New(
+		server.WithAutoCert(server.ServerTLSAutoCert{Domains: []string{"example.com"}}),
+		server.WithManualTLS("cert.pem", "key.pem"),
+	)
+}
+
+// Not parallel: it replaces the process-wide default logger.
+func TestWithDefaultLogger(t *testing.T) {
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	var output bytes.Buffer
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(&output, nil)))
+
+	srv := server.New(server.WithDefaultLogger())
+
+	if srv.Logger == nil {
+		t.Fatal("expected logger to be set")
+	}
+
+	srv.Logger.Info("hello")
+
+	if !strings.Contains(output.String(), "msg=hello") {
+		t.Errorf("expected default logger to write, got %q", output.String())
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// proxyHeadersHandler rewrites requests from TrustedProxies with the client's address, scheme and
+// host from X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host. Forwarded headers from other
+// peers are ignored. An invalid TrustedProxies, which Validate reports, trusts no one.
+func (server *Server) proxyHeadersHandler(next http.Handler) http.Handler {
+	trusted, err := parsePrefixes("TrustedProxies", server.TrustedProxies)
+	if err != nil {
+		trusted = nil
+	}
+
+	isTrusted := func(addr netip.Addr) bool {
+		return slices.ContainsFunc(trusted, func(prefix netip.Prefix) bool {
+			return prefix.Contains(addr)
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, port, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		peer, err := netip.ParseAddr(host)
+		if err != nil || !isTrusted(peer.Unmap()) {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		r = r.Clone(r.Context())
+
+		client, ok := forwardedClient(r.Header.Values("X-Forwarded-For"), isTrusted)
+		if ok {
+			r.RemoteAddr = net.JoinHostPort(client.String(), port)
+		}
+
+		proto := strings.ToLower(firstForwardedValue(r.Header.Get("X-Forwarded-Proto")))
+		if proto == "http" || proto == "https" {
+			r.URL.Scheme = proto
+		}
+
+		forwardedHost := firstForwardedValue(r.Header.Get("X-Forwarded-Host"))
+		if forwardedHost != "" && !strings.ContainsAny(forwardedHost, "/\\@ ") {
+			r.Host = forwardedHost
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedClient returns the rightmost address in X-Forwarded-For that is not a trusted proxy:
+// entries left of it may have been sent by the client itself. It reports false when an entry it
+// reaches is malformed or there is none.
+func forwardedClient(values []string, isTrusted func(netip.Addr) bool) (netip.Addr, bool) {
+	var hops []string
+
+	for _, value := range values {
+		for hop := range strings.SplitSeq(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	var client netip.Addr
+
+	for _, hop := range slices.Backward(hops) {
+		addr, err := netip.ParseAddr(hop)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+
+		client = addr.Unmap()
+		if !isTrusted(client) {
+			break
+		}
+	}
+
+	return client, client.IsValid()
+}
+
+// firstForwardedValue returns the first of the comma-separated values of a forwarded header.
+func firstForwardedValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+
+	return strings.TrimSpace(first)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyHeaders(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		trustedProxies     []string
+		remoteAddr         string
+		headers            map[string][]string
+		expectedRemoteAddr string
+		expectedScheme     string
+		expectedHost       string
+	}{
+		{
+			name:           "trusted proxy",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:4321",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"app.example.com"},
+			},
+			expectedRemoteAddr: "203.0.113.7:4321",
+			expectedScheme:     "https",
+			expectedHost:       "app.example.com",
+		},
+		{
+			name:           "untrusted peer",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "198.51.100.1:4321",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"app.example.com"},
+			},
+			expectedRemoteAddr: "198.51.100.1:4321",
+			expectedHost:       "example.com",
+		},
+		{
+			name:           "spoofed entry before the client",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:4321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"127.0.0.1, 203.0.113.7"},
+			},
+			expectedRemoteAddr: "203.0.113.7:4321",
+			expectedHost:       "example.com",
+		},
+		{
+			name:           "chain of trusted proxies",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:4321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.7, 10.0.0.2", "10.0.0.3"},
+			},
+			expectedRemoteAddr: "203.0.113.7:4321",
+			expectedHost:       "example.com",
+		},
+		{
+			name:           "spoofed trusted address",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "198.51.100.1:4321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.0.0.1"},
+			},
+			expectedRemoteAddr: "198.51.100.1:4321",
+			expectedHost:       "example.com",
+		},
+		{
+			name:           "malformed headers",
+			trustedProxies: []string{"10.0.0.1"},
+			remoteAddr:     "10.0.0.1:4321",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"not-an-ip"},
+				"X-Forwarded-Proto": {"gopher"},
+				"X-Forwarded-Host":  {"evil.example.com/path"},
+			},
+			expectedRemoteAddr: "10.0.0.1:4321",
+			expectedHost:       "example.com",
+		},
+		{
+			name:           "malformed config trusts no one",
+			trustedProxies: []string{"10.0.0.0/8", "10.0.0.0/33"},
+			remoteAddr:     "10.0.0.1:4321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.7"},
+			},
+			expectedRemoteAddr: "10.0.0.1:4321",
+			expectedHost:       "example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{TrustedProxies: tt.trustedProxies}
+
+			var got *http.Request
+
+			handler := srv.wrapHandler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = r
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got.RemoteAddr != tt.expectedRemoteAddr {
+				t.Errorf("expected remote address %q, got %q", tt.expectedRemoteAddr, got.RemoteAddr)
+			}
+
+			if got.URL.Scheme != tt.expectedScheme {
+				t.Errorf("expected scheme %q, got %q", tt.expectedScheme, got.URL.Scheme)
+			}
+
+			if got.Host != tt.expectedHost {
+				t.Errorf("expected host %q, got %q", tt.expectedHost, got.Host)
+			}
+		})
+	}
+}
+
+func TestValidate_TrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{TrustedProxies: []string{"10.0.0.0/8", "proxy.internal"}}
+
+	err := srv.Validate()
+	if err == nil || !strings.Contains(err.Error(), `TrustedProxies entry "proxy.internal"`) {
+		t.Errorf("expected an error for the malformed entry, got %v", err)
+	}
+}
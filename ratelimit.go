@@ -0,0 +1,151 @@
+package server
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultRateLimitMaxKeys is the number of rate limit buckets kept when RateLimitConfig.MaxKeys is not set.
+const DefaultRateLimitMaxKeys = 10000
+
+// ErrInvalidRateLimit is returned by Validate when RateLimit.RequestsPerSecond is not positive.
+var ErrInvalidRateLimit = errors.New("RateLimit.RequestsPerSecond must be positive")
+
+// RateLimitConfig configures per-client rate limiting with token buckets.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the rate at which each bucket refills.
+	RequestsPerSecond float64
+	// Burst is the bucket size, the number of requests allowed at once. Defaults to
+	// RequestsPerSecond rounded up.
+	Burst int
+	// KeyFunc returns the bucket of a request. Defaults to the client IP from r.RemoteAddr.
+	KeyFunc func(r *http.Request) string
+	// MaxKeys bounds the number of buckets kept; the least recently used one is dropped beyond it.
+	// Defaults to DefaultRateLimitMaxKeys.
+	MaxKeys int
+}
+
+// rateLimiter holds the token buckets of the most recently seen keys.
+type rateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	maxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	recent  *list.List
+}
+
+type rateLimitBucket struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+// rateLimitHandler answers 429 with a Retry-After header to requests over their bucket's rate.
+func (server *Server) rateLimitHandler(next http.Handler) http.Handler {
+	config := server.RateLimit
+
+	limiter := &rateLimiter{
+		limit:   rate.Limit(config.RequestsPerSecond),
+		burst:   config.Burst,
+		maxKeys: config.MaxKeys,
+		buckets: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+
+	if limiter.burst <= 0 {
+		limiter.burst = max(1, int(math.Ceil(config.RequestsPerSecond)))
+	}
+
+	if limiter.maxKeys <= 0 {
+		limiter.maxKeys = DefaultRateLimitMaxKeys
+	}
+
+	keyFunc := config.KeyFunc
+	if keyFunc == nil {
+		keyFunc = clientIP
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		retryAfter, ok := limiter.allow(keyFunc(r), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from the bucket of key, or reports how long until one is available.
+func (limiter *rateLimiter) allow(key string, now time.Time) (time.Duration, bool) {
+	bucket := limiter.bucket(key)
+
+	reservation := bucket.ReserveN(now, 1)
+	if !reservation.OK() {
+		return time.Second, false
+	}
+
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+
+		return delay, false
+	}
+
+	return 0, true
+}
+
+// bucket returns the limiter of key, creating it and dropping the least recently used one if needed.
+func (limiter *rateLimiter) bucket(key string) *rate.Limiter {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	element, ok := limiter.buckets[key]
+	if ok {
+		limiter.recent.MoveToFront(element)
+
+		return element.Value.(*rateLimitBucket).limiter
+	}
+
+	if limiter.recent.Len() >= limiter.maxKeys {
+		oldest := limiter.recent.Back()
+		limiter.recent.Remove(oldest)
+		delete(limiter.buckets, oldest.Value.(*rateLimitBucket).key)
+	}
+
+	bucket := &rateLimitBucket{key: key, limiter: rate.NewLimiter(limiter.limit, limiter.burst)}
+	limiter.buckets[key] = limiter.recent.PushFront(bucket)
+
+	return bucket.limiter
+}
+
+// clientIP returns the host of r.RemoteAddr.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func (config *RateLimitConfig) clone() *RateLimitConfig {
+	if config == nil {
+		return nil
+	}
+
+	clone := *config
+
+	return &clone
+}
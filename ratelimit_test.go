@@ -0,0 +1,135 @@
+package server
+
+import (
+	"container/list"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{RateLimit: &RateLimitConfig{RequestsPerSecond: 5, Burst: 2}}
+
+	handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	for i := range 2 {
+		if rec := request("192.0.2.1:1234"); rec.Code != http.StatusTeapot {
+			t.Fatalf("expected request %d within burst to pass, got %d", i+1, rec.Code)
+		}
+	}
+
+	rec := request("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d after burst, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After %q, got %q", "1", got)
+	}
+
+	if rec := request("192.0.2.2:1234"); rec.Code != http.StatusTeapot {
+		t.Errorf("expected another client to have its own bucket, got %d", rec.Code)
+	}
+
+	// One token is added every 200ms.
+	time.Sleep(250 * time.Millisecond)
+
+	if rec := request("192.0.2.1:1234"); rec.Code != http.StatusTeapot {
+		t.Errorf("expected request to pass after refill, got %d", rec.Code)
+	}
+}
+
+func TestRateLimit_KeyFunc(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		RateLimit: &RateLimitConfig{
+			RequestsPerSecond: 1,
+			KeyFunc: func(r *http.Request) string {
+				return r.Header.Get("X-API-Key")
+			},
+		},
+	}
+
+	handler := srv.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		apiKey   string
+		expected int
+	}{
+		{apiKey: "a", expected: http.StatusTeapot},
+		{apiKey: "a", expected: http.StatusTooManyRequests},
+		{apiKey: "b", expected: http.StatusTeapot},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", tt.apiKey)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expected {
+			t.Errorf("expected status %d for key %q, got %d", tt.expected, tt.apiKey, rec.Code)
+		}
+	}
+}
+
+func TestRateLimiter_EvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	limiter := &rateLimiter{
+		limit:   1,
+		burst:   1,
+		maxKeys: 2,
+		buckets: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+
+	now := time.Now()
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		limiter.allow(key, now)
+	}
+
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(limiter.buckets))
+	}
+
+	if _, ok := limiter.buckets["b"]; ok {
+		t.Error("expected the least recently used bucket to be evicted")
+	}
+
+	if _, ok := limiter.allow("a", now); ok {
+		t.Error("expected the bucket of a recently used key to be kept")
+	}
+}
+
+func TestValidate_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{RateLimit: &RateLimitConfig{Burst: 10}}
+
+	err := srv.Validate()
+	if !errors.Is(err, ErrInvalidRateLimit) {
+		t.Errorf("expected %v, got %v", ErrInvalidRateLimit, err)
+	}
+}
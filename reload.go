@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ErrReloadRequiresRestart is returned by Instance.Reload when the new configuration changes a
+// field that only takes effect on a restart, such as Host, Port or Timeouts.Idle.
+var ErrReloadRequiresRestart = errors.New("configuration change requires a restart")
+
+// errInstanceStopped is returned by Instance.Reload once the server has stopped.
+var errInstanceStopped = errors.New("server is not running")
+
+// Reload applies the fields of cfg that can change while the server runs: Timeouts.Read and
+// Timeouts.Write, which apply to requests read from then on, and, in manual TLS mode, TLS.CertFile,
+// TLS.KeyFile and TLS.Certificates, which apply to new handshakes. Use SetHandler to swap the handler.
+// Changing Host, Port, Network, TLS.Enabled, TLS.Mode, Timeouts.ReadHeader, Timeouts.Idle or
+// ShutdownTimeout returns ErrReloadRequiresRestart; other fields of cfg are ignored. Nothing is
+// applied when an error is returned.
+func (instance *Instance) Reload(ctx context.Context, cfg *Server) error {
+	select {
+	case <-instance.done:
+		return errInstanceStopped
+	default:
+	}
+
+	return instance.server.reload(ctx, cfg)
+}
+
+func (server *Server) reload(ctx context.Context, cfg *Server) error {
+	err := server.checkReloadable(cfg)
+	if err != nil {
+		return err
+	}
+
+	reloadCertificates := server.TLS.Enabled && server.tlsMode() == TLSModeManual
+
+	var certificates []tls.Certificate
+
+	if reloadCertificates {
+		certificates, err = cfg.manualCertificates()
+		if err != nil {
+			return err
+		}
+
+		if server.TLS.EnableOCSPStapling {
+			certificates, _ = server.stapleOCSP(ctx, certificates)
+		}
+	}
+
+	server.mu.Lock()
+
+	server.Timeouts.Read = cfg.Timeouts.Read
+	server.Timeouts.Write = cfg.Timeouts.Write
+
+	if reloadCertificates {
+		server.TLS.CertFile = cfg.TLS.CertFile
+		server.TLS.KeyFile = cfg.TLS.KeyFile
+		server.TLS.Certificates = slices.Clone(cfg.TLS.Certificates)
+	}
+
+	server.mu.Unlock()
+
+	timeouts := cfg.Timeouts
+	server.reloadedTimeouts.Store(&timeouts)
+
+	if reloadCertificates {
+		server.certificates.Store(&certificates)
+	}
+
+	server.logLifecycle(ctx, "configuration reloaded")
+
+	return nil
+}
+
+// checkReloadable returns ErrReloadRequiresRestart if cfg changes a field bound to the listener or
+// to the running http.Server.
+func (server *Server) checkReloadable(cfg *Server) error {
+	port := cfg.Port
+	if port == "" {
+		port = DefaultPort
+	}
+
+	mode := cfg.TLS.Mode
+	if mode == "" {
+		mode = DefaultTLSMode
+	}
+
+	changed := []struct {
+		name    string
+		changed bool
+	}{
+		{name: "Host", changed: cfg.Host != server.Host},
+		{name: "Port", changed: port != server.Port},
+		{name: "Network", changed: cfg.network() != server.network()},
+		{name: "TLS.Enabled", changed: cfg.TLS.Enabled != server.TLS.Enabled},
+		{name: "TLS.Mode", changed: cfg.TLS.Enabled && mode != server.TLS.Mode},
+		{name: "Timeouts.ReadHeader", changed: cfg.Timeouts.ReadHeader != server.Timeouts.ReadHeader},
+		{name: "Timeouts.Idle", changed: cfg.Timeouts.Idle != server.Timeouts.Idle},
+		{name: "ShutdownTimeout", changed: cfg.ShutdownTimeout != server.ShutdownTimeout},
+	}
+
+	for _, field := range changed {
+		if field.changed {
+			return fmt.Errorf("%w: %s changed", ErrReloadRequiresRestart, field.name)
+		}
+	}
+
+	return nil
+}
+
+// reloadedTimeoutsHandler applies the read and write timeouts of the last Reload to each request as
+// deadlines, as http.Server's own timeouts cannot change while it serves.
+func (server *Server) reloadedTimeoutsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeouts := server.reloadedTimeouts.Load()
+		if timeouts == nil || server.StreamingMode {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		now := time.Now()
+		rc := http.NewResponseController(w)
+
+		// Connections that do not support deadlines, such as HTTP/3 streams, keep their timeouts.
+		_ = rc.SetReadDeadline(now.Add(timeoutOrDefault(timeouts.Read)))
+		_ = rc.SetWriteDeadline(now.Add(timeoutOrDefault(timeouts.Write)))
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+)
+
+// DefaultRequestIDHeader is the header carrying the request ID when RequestIDConfig.HeaderName is not set.
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming request IDs, which end up in logs and response headers.
+const maxRequestIDLength = 128
+
+// RequestIDConfig configures request ID propagation.
+type RequestIDConfig struct {
+	// HeaderName is the request header an incoming ID is read from and the response header it is
+	// echoed in. Defaults to DefaultRequestIDHeader.
+	HeaderName string
+	// Generator returns the ID of requests without a valid one. Defaults to rand.Text.
+	Generator func() string
+}
+
+// RequestIDKey is the request context key of the request ID, a string. Use RequestIDFromContext
+// to read it.
+type RequestIDKey struct{}
+
+// RequestIDFromContext returns the request ID stored in ctx by the RequestID option, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(RequestIDKey{}).(string)
+
+	return requestID
+}
+
+// requestIDHandler stores the request ID of incoming requests, or a generated one, in their
+// context and echoes it in the response header.
+func (server *Server) requestIDHandler(next http.Handler) http.Handler {
+	headerName := server.RequestID.HeaderName
+	if headerName == "" {
+		headerName = DefaultRequestIDHeader
+	}
+
+	generate := server.RequestID.Generator
+	if generate == nil {
+		generate = rand.Text
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(headerName)
+		if !validRequestID(requestID) {
+			requestID = generate()
+		}
+
+		w.Header().Set(headerName, requestID)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), RequestIDKey{}, requestID)))
+	})
+}
+
+// validRequestID reports whether an incoming request ID is short and printable ASCII, so it can be
+// logged and echoed safely.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for i := range len(requestID) {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (config *RequestIDConfig) clone() *RequestIDConfig {
+	if config == nil {
+		return nil
+	}
+
+	clone := *config
+
+	return &clone
+}
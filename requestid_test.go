@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		config     RequestIDConfig
+		headerName string
+		incomingID string
+		expectedID string
+	}{
+		{
+			name:       "incoming ID is preserved",
+			headerName: DefaultRequestIDHeader,
+			incomingID: "abc-123",
+			expectedID: "abc-123",
+		},
+		{
+			name:       "missing ID is generated",
+			config:     RequestIDConfig{Generator: func() string { return "generated" }},
+			headerName: DefaultRequestIDHeader,
+			expectedID: "generated",
+		},
+		{
+			name:       "invalid ID is replaced",
+			config:     RequestIDConfig{Generator: func() string { return "generated" }},
+			headerName: DefaultRequestIDHeader,
+			incomingID: "abc\x00def",
+			expectedID: "generated",
+		},
+		{
+			name:       "overlong ID is replaced",
+			config:     RequestIDConfig{Generator: func() string { return "generated" }},
+			headerName: DefaultRequestIDHeader,
+			incomingID: strings.Repeat("a", maxRequestIDLength+1),
+			expectedID: "generated",
+		},
+		{
+			name:       "custom header",
+			config:     RequestIDConfig{HeaderName: "X-Correlation-ID"},
+			headerName: "X-Correlation-ID",
+			incomingID: "abc-123",
+			expectedID: "abc-123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{RequestID: &tt.config}
+
+			var got string
+
+			handler := srv.wrapHandler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = RequestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incomingID != "" {
+				req.Header.Set(tt.headerName, tt.incomingID)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got != tt.expectedID {
+				t.Errorf("expected request ID %q in context, got %q", tt.expectedID, got)
+			}
+
+			if header := rec.Header().Get(tt.headerName); header != tt.expectedID {
+				t.Errorf("expected %s header %q, got %q", tt.headerName, tt.expectedID, header)
+			}
+		})
+	}
+}
+
+func TestRequestID_DefaultGenerator(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{RequestID: &RequestIDConfig{}}
+
+	ids := make(map[string]bool)
+
+	handler := srv.wrapHandler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ids[RequestIDFromContext(r.Context())] = true
+	}))
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Header().Get(DefaultRequestIDHeader) == "" {
+			t.Fatalf("expected a generated %s header", DefaultRequestIDHeader)
+		}
+	}
+
+	if len(ids) != 2 || ids[""] {
+		t.Errorf("expected 2 distinct generated IDs, got %v", ids)
+	}
+}
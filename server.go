@@ -3,166 +3,2134 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/pires/go-proxyproto"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
 )
 
 const (
 	HTTPServerTimeOut = 60 * time.Second
 	ShutdownTimeout   = 5 * time.Second
+	// StreamingIdleTimeout is the idle timeout in StreamingMode when Timeouts.Idle is not set.
+	StreamingIdleTimeout = 5 * time.Minute
+	// LowTLSWriteTimeout is the Timeouts.Write below which a TLS server logs a warning at startup:
+	// the write timeout starts when an HTTP/1.1 connection is accepted, so it also bounds the TLS
+	// handshake, which can be slow on poor networks or while autocert obtains a certificate.
+	LowTLSWriteTimeout = 5 * time.Second
+	// SlowlorisReadHeaderTimeout is the header read timeout with SlowlorisProtection when
+	// Timeouts.ReadHeader is not set.
+	SlowlorisReadHeaderTimeout = 10 * time.Second
 )
 
 const (
 	TLSModeAutoCert = "autocert"
-	TLSModeManual   = "manual"
+	// TLSModeAutoCertTLSALPN is autocert restricted to the TLS-ALPN-01 challenge, answered by the main
+	// TLS listener. No HTTP challenge server is started, so the domains must resolve to that listener on port 443.
+	TLSModeAutoCertTLSALPN = "autocert-tls-alpn"
+	TLSModeManual          = "manual"
 )
 
 const (
-	DefaultPort    = "8080"
-	DefaultTLSMode = TLSModeAutoCert
+	NetworkTCP  = "tcp"
+	NetworkTCP4 = "tcp4"
+	NetworkTCP6 = "tcp6"
+	NetworkUnix = "unix"
 )
 
+// UnixSocketMode is the file mode applied to Unix domain sockets, allowing access to the owning group.
+const UnixSocketMode os.FileMode = 0o660
+
+const (
+	DefaultNetwork           = NetworkTCP
+	DefaultPort              = "8080"
+	DefaultTLSMode           = TLSModeAutoCert
+	DefaultACMEChallengePort = "80"
+	DefaultTLSMinVersion     = tls.VersionTLS12
+	DefaultPreObtainAttempts = 5
+	DefaultPreObtainBackoff  = 2 * time.Second
+)
+
+var supportedTLSVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// ErrTOSNotAccepted is returned in autocert mode when neither AutoCert.AcceptTOS nor AutoCert.Prompt is set.
+var ErrTOSNotAccepted = errors.New("autocert requires accepting the ACME Terms of Service: set TLS.AutoCert.AcceptTOS or TLS.AutoCert.Prompt")
+
+// ErrAutoCertConfigMissing is returned in autocert mode when TLS.AutoCert is nil.
+var ErrAutoCertConfigMissing = errors.New("autocert mode requires TLS.AutoCert configuration")
+
+// ErrNoDomains is returned in autocert mode when there are no domains and no custom host policy.
+var ErrNoDomains = errors.New("autocert mode requires at least one domain in TLS.AutoCert.Domains or a TLS.AutoCert.HostPolicy")
+
+// ErrIncompleteKeyPair is returned in manual TLS mode when only one of CertFile and KeyFile is set.
+var ErrIncompleteKeyPair = errors.New("manual TLS mode requires both TLS.CertFile and TLS.KeyFile")
+
+// ErrNoCertificates is returned in manual TLS mode when neither CertFile/KeyFile nor Certificates are set.
+var ErrNoCertificates = errors.New("manual TLS mode requires TLS.CertFile and TLS.KeyFile or TLS.Certificates")
+
+// ErrPreObtainRequiresHTTPChallenge is returned when AutoCert.PreObtain is set but the HTTP challenge
+// is disabled: TLS-ALPN-01 challenges can only be answered once the server is serving.
+var ErrPreObtainRequiresHTTPChallenge = errors.New("TLS.AutoCert.PreObtain requires the ACME HTTP challenge")
+
+// ErrMaxLifetimeReached is the StopCause of a server shut down because it ran for MaxLifetime.
+var ErrMaxLifetimeReached = errors.New("max lifetime reached")
+
+// ErrRunMultiTLS is returned by RunMulti when TLS is enabled: it only serves plain HTTP, and
+// serving the addresses without the configured TLS would be a silent downgrade.
+var ErrRunMultiTLS = errors.New("RunMulti does not support TLS: disable TLS.Enabled or use RunHTTPAndTLS")
+
+// ErrChallengePortConflict is returned when the ACME challenge port equals the TLS port.
+var ErrChallengePortConflict = errors.New("ACME challenge port must differ from the TLS port")
+
+// ErrInvalidRenewBefore is returned when AutoCert.RenewBefore is negative, or positive but no longer
+// than an hour, which autocert would silently replace with its 30-day default.
+var ErrInvalidRenewBefore = errors.New("TLS.AutoCert.RenewBefore must be zero or longer than an hour")
+
+// ErrSessionTicketRotationConflict is returned when TLS.SessionTicketKeyRotation is set together with
+// a TLS config that has its own WrapSession or UnwrapSession, which the rotation would replace.
+var ErrSessionTicketRotationConflict = errors.New("TLS.SessionTicketKeyRotation cannot be combined with WrapSession or UnwrapSession in the TLS config")
+
+// errRunStopped is the cancellation cause of a server stopped because another server of the same
+// run stopped. It shuts down without PreShutdownDelay, as nothing routes traffic away from it.
+var errRunStopped = errors.New("another server of the run stopped")
+
 var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
 
-// Server represents the HTTP server.
-type Server struct {
-	Port   string
-	Host   string
-	TLS    ServerTLS
-	Logger *slog.Logger
-}
+// Server represents the HTTP server.
+type Server struct {
+	Port string
+	Host string
+	// Network is the listen network, NetworkTCP (dual-stack per OS) by default. NetworkTCP4 and
+	// NetworkTCP6 restrict binding to IPv4 or IPv6. With NetworkUnix, Host is the
+	// socket path and Port is ignored; a stale socket file is removed before binding.
+	Network  string
+	TLS      ServerTLS
+	Logger   *slog.Logger
+	Timeouts Timeouts
+	// ShutdownTimeout bounds graceful shutdown. Zero falls back to the ShutdownTimeout constant.
+	ShutdownTimeout time.Duration
+	// PreShutdownDelay, when positive, keeps the server serving for this long once the run context is
+	// canceled, with ReadyPath already answering 503, before graceful shutdown starts. It gives load
+	// balancers, such as Kubernetes endpoints, time to stop routing to the server. It does not count
+	// against ShutdownTimeout.
+	PreShutdownDelay time.Duration
+	// ShutdownBaseContext is the parent of the shutdown context passed to http.Server.Shutdown and
+	// OnShutdown hooks. When nil, the run context is used without its cancellation, so values such
+	// as trace IDs carry over into shutdown.
+	ShutdownBaseContext context.Context
+	// OnShutdown hooks run sequentially with the shutdown context once the server has stopped
+	// accepting requests during graceful shutdown. Panics in hooks are recovered and logged.
+	OnShutdown []func(context.Context)
+	// EnableH2C serves HTTP/2 over cleartext connections (h2c) when TLS is disabled,
+	// e.g. behind a load balancer that terminates TLS and speaks HTTP/2 to backends.
+	EnableH2C bool
+	// HTTP2 tunes HTTP/2 (e.g. MaxConcurrentStreams, MaxReadFrameSize) for TLS and h2c connections.
+	// When nil, Go's defaults apply.
+	HTTP2 *http2.Server
+	// ConfigureHTTPServer is called with every underlying http.Server, including the ACME challenge
+	// server, after this package has applied its settings and before it starts serving.
+	// It is an escape hatch for http.Server fields without a dedicated option, such as
+	// TLSNextProto.
+	ConfigureHTTPServer func(*http.Server)
+	// OnReady is called with the bound address once the listener is open, before the first
+	// connection is accepted. It is not called when binding fails.
+	OnReady func(addr net.Addr)
+	// ReadinessCheck, when set, is called once the listener is bound and before it is served, e.g. to
+	// verify that a database is reachable. If it fails, the listener is closed without accepting any
+	// connection and Run returns the error. RunMulti and RunHTTPAndTLS call it once, after all their
+	// listeners are bound and before any is served.
+	ReadinessCheck func(ctx context.Context) error
+	// MaxHeaderBytes limits the size of request headers. Zero keeps http.DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+	// ConnState is called on connection state changes of every underlying http.Server,
+	// including the ACME challenge server.
+	ConnState func(net.Conn, http.ConnState)
+	// KeepAlivePeriod is the TCP keep-alive period of accepted connections. Zero keeps Go's
+	// default; a negative value disables keep-alives.
+	KeepAlivePeriod time.Duration
+	// ListenConfig, when set, is used to bind every listener, including the ACME challenge and
+	// HTTP/3 ones, e.g. with a Control function setting SO_REUSEPORT. It is copied, and a non-zero
+	// KeepAlivePeriod overrides its KeepAlive.
+	ListenConfig *net.ListenConfig
+	// Middleware wraps the handler, Middleware[0] outermost, so it sees requests first. Handlers set
+	// with SetHandler are wrapped too. Built-in options such as CORS, RecoverPanics, HandlerTimeout,
+	// AccessLog and the health paths wrap all of them.
+	Middleware []func(http.Handler) http.Handler
+	// MaxRequestBodyBytes, when positive, limits request bodies. Requests whose Content-Length exceeds
+	// it get 413 without reaching the handler; otherwise reading past it fails with *http.MaxBytesError,
+	// which handlers should answer with 413.
+	MaxRequestBodyBytes int64
+	// ConditionalRequests, when set, gives 200 responses to GET a weak ETag computed from their body
+	// and answers requests whose If-None-Match or If-Modified-Since matches with 304. Responses are
+	// buffered up to ConditionalRequests.MaxBodySize for it.
+	ConditionalRequests *ConditionalRequestsConfig
+	// Compression, when set, gzips responses of the configured types and sizes for clients that
+	// accept it.
+	Compression *CompressionConfig
+	// CORS, when set, adds Access-Control-* headers for allowed origins and answers their preflight
+	// requests before they reach Middleware and the handler.
+	CORS *CORSConfig
+	// RecoverPanics recovers panics in the handler, logs them with the request method and path
+	// and responds with 500 instead of letting the http package reset the connection.
+	RecoverPanics bool
+	// HandlerTimeout, when positive, bounds each request with http.TimeoutHandler: a handler still
+	// running after it gets its context canceled and the client gets 503 with HandlerTimeoutMessage.
+	// The response is buffered, so streaming, http.Flusher and http.Hijacker do not work with it.
+	HandlerTimeout time.Duration
+	// HandlerTimeoutMessage is the body of the 503 sent on HandlerTimeout. Empty uses Go's default.
+	HandlerTimeoutMessage string
+	// TrustedProxies lists the CIDRs or IPs of reverse proxies in front of the server. Requests from
+	// them get r.RemoteAddr, r.URL.Scheme and r.Host from the X-Forwarded-For, X-Forwarded-Proto and
+	// X-Forwarded-Host headers; these headers are ignored from other peers. Invalid entries are
+	// reported by Validate.
+	TrustedProxies []string
+	// IPFilter, when set, answers 403 to clients outside its allow list or in its deny list.
+	// Invalid entries are reported by Validate.
+	IPFilter *IPFilterConfig
+	// RateLimit, when set, limits each client, by IP unless RateLimit.KeyFunc is set, to a token
+	// bucket and answers 429 with a Retry-After header beyond it.
+	RateLimit *RateLimitConfig
+	// AccessLog logs one line per request with method, path, status, duration and bytes written.
+	AccessLog bool
+	// RequestID, when set, gives every request an ID, taken from its header or generated, stores it
+	// in the request context under RequestIDKey and echoes it in the response header.
+	RequestID *RequestIDConfig
+	// OnError is called with errors from background work that Run cannot return, such as a failing
+	// ACME challenge server, a failed certificate reload or a panicking shutdown hook. They are logged too.
+	OnError func(ctx context.Context, err error)
+	// OnTLSHandshakeError is called when a TLS handshake fails, e.g. because the client supports no
+	// allowed TLS version or asks for an unknown host. info is the client's ClientHello, or nil when
+	// the handshake failed before it was read. The failures are logged to ErrorLog too. HTTP/3
+	// handshakes are not reported.
+	OnTLSHandshakeError func(info *tls.ClientHelloInfo, err error)
+	// EnableProxyProtocol decodes PROXY protocol v1/v2 headers on accepted connections, so
+	// r.RemoteAddr is the client address sent by the load balancer. Headers are trusted from any
+	// peer, so only enable it when the listener is reachable through the proxy alone. The ACME
+	// challenge server is not affected, as the CA connects to it directly.
+	EnableProxyProtocol bool
+	// RequireProxyHeader rejects connections without a PROXY header when EnableProxyProtocol is set.
+	// Otherwise they are served with their TCP remote address.
+	RequireProxyHeader bool
+	// MaxConnections caps the number of connections served at once; further connections wait to be
+	// accepted until one closes. Zero means unlimited. It does not apply to the ACME challenge server.
+	MaxConnections int
+	// MaxLifetime, when positive, shuts the server down gracefully once it has run this long, as if
+	// the run context was canceled, e.g. to rebalance connections during rolling restarts. Run returns
+	// nil and StopCause is ErrMaxLifetimeReached.
+	MaxLifetime time.Duration
+	// OnDrain is called with the number of in-flight requests while the server shuts down: when
+	// shutdown starts, periodically while it waits, and once more when it is done.
+	OnDrain func(inFlight int)
+	// OnShutdownComplete is called at the end of graceful shutdown, after OnShutdown hooks, with how
+	// long draining took, whether remaining connections had to be closed after ShutdownTimeout and
+	// the shutdown error, if any.
+	OnShutdownComplete func(d time.Duration, forced bool, err error)
+	// BaseContext returns the base context for requests on every underlying http.Server, e.g. to
+	// inject server-scoped values. When nil, requests use the context passed to Run, so they are
+	// canceled on shutdown; a custom BaseContext should derive from a context with that behavior.
+	BaseContext func(net.Listener) context.Context
+	// ConnContext derives the context of each new connection from the base context, e.g. to stash
+	// the remote address. Values it adds are visible in r.Context() of the connection's requests.
+	// For HTTP/3 connections, conn only reports the addresses and can be closed.
+	ConnContext func(ctx context.Context, conn net.Conn) context.Context
+	// HealthPath, when set, is answered with 200 by the server itself for liveness probes, also
+	// while it drains on shutdown.
+	HealthPath string
+	// ReadyPath, when set, is answered by the server itself for readiness probes: 200 while serving
+	// and 503 once graceful shutdown has started, so load balancers stop routing to it.
+	ReadyPath string
+	// ErrorLog receives the errors of every underlying http.Server, such as TLS handshake failures.
+	// When nil and Logger is set, they are logged through Logger at warn level; when both are nil,
+	// the http package logs them to the standard logger.
+	ErrorLog *log.Logger
+	// DisableGeneralOptionsHandler passes "OPTIONS *" requests to the handler instead of answering
+	// them with 200 automatically. It applies to every underlying http.Server.
+	DisableGeneralOptionsHandler bool
+	// DisableKeepAlives closes every connection after its response, which is sent with
+	// "Connection: close". It applies to every underlying http.Server.
+	DisableKeepAlives bool
+	// StreamingMode removes the read and write timeouts of the application servers, so long-lived
+	// responses such as SSE and WebSocket streams are not cut off. Timeouts.ReadHeader still protects
+	// against slowloris clients, and idle connections are closed after Timeouts.Idle, or
+	// StreamingIdleTimeout if it is not set. Handlers can still bound a request with
+	// http.ResponseController's SetReadDeadline and SetWriteDeadline.
+	StreamingMode bool
+	// SlowlorisProtection gives clients SlowlorisReadHeaderTimeout instead of HTTPServerTimeOut to
+	// send their request headers when Timeouts.ReadHeader is not set, so slow clients cannot hold
+	// connections open for long. It applies to every underlying http.Server, independently of
+	// Timeouts.Read.
+	SlowlorisProtection bool
+	// LogLevel is the level of lifecycle messages such as "starting server" and "server shut down
+	// gracefully". The zero value is slog.LevelInfo; set slog.LevelDebug to quiet them.
+	LogLevel slog.Level
+
+	mu               sync.Mutex
+	ready            chan net.Addr
+	addr             net.Addr
+	httpServer       *http.Server
+	baseHTTPServer   *http.Server
+	serving          *serveRun
+	serveGen         uint64
+	pendingShutdown  uint64
+	started          bool
+	shutdownFuncs    []func()
+	connTrackers     map[*http.Server]*connTracker
+	certificates     atomic.Pointer[[]tls.Certificate]
+	inFlight         atomic.Int64
+	handler          atomic.Pointer[http.Handler]
+	reloadedTimeouts atomic.Pointer[Timeouts]
+	draining         atomic.Bool
+	stopCause        error
+}
+
+// Timeouts overrides the timeouts of the underlying http.Server.
+// Zero values fall back to HTTPServerTimeOut.
+type Timeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+type ServerTLS struct {
+	Enabled  bool
+	Mode     string
+	AutoCert *ServerTLSAutoCert
+	CertFile string
+	KeyFile  string
+	// Certificates are served alongside CertFile/KeyFile in manual mode;
+	// the certificate is selected by the SNI in the client hello.
+	Certificates []tls.Certificate
+	// ReloadOnSIGHUP reloads the manual certificates when the process receives SIGHUP.
+	// See Server.ReloadCertificates.
+	ReloadOnSIGHUP bool
+	// MinVersion is the minimum TLS version accepted, one of the tls.Version* constants.
+	// Defaults to DefaultTLSMinVersion.
+	MinVersion uint16
+	// CipherSuites restricts the cipher suites offered for TLS 1.0-1.2.
+	// Go ignores it for TLS 1.3, whose suites are not configurable. Empty keeps Go's defaults.
+	CipherSuites []uint16
+	// CurvePreferences restricts the elliptic curves used in key exchange. Empty keeps Go's defaults.
+	CurvePreferences []tls.CurveID
+	// ClientCAFile is a PEM file of CAs used to verify client certificates (mutual TLS).
+	ClientCAFile string
+	// ClientAuth is the client certificate policy applied when ClientCAFile is set.
+	// Defaults to tls.RequireAndVerifyClientCert.
+	ClientAuth tls.ClientAuthType
+	// EnableOCSPStapling staples OCSP responses to the manual certificates and refreshes them halfway
+	// through their validity until the server stops. The chain must include the issuer after the leaf,
+	// and the leaf must name an OCSP responder. Stapling failures are logged and passed to OnError.
+	EnableOCSPStapling bool
+	// Config is the base TLS configuration, e.g. with session tickets, GetConfigForClient or OCSP
+	// already set. It is cloned; the fields above are applied on top, MinVersion only as a floor, and
+	// the mode's certificates are only installed if it has neither GetCertificate nor Certificates.
+	Config *tls.Config
+	// SessionTicketKeys encrypt TLS session tickets; the first key encrypts new tickets and all of them
+	// decrypt. Sharing them lets clients resume sessions across instances. Empty keeps Go's
+	// automatically rotated keys.
+	SessionTicketKeys [][32]byte
+	// SessionTicketKeyRotation, when positive, generates a new first session ticket key at this
+	// interval while the server runs, keeping the previous keys to decrypt tickets issued with them.
+	// As many keys are kept as SessionTicketKeys has, and at least two. It sets WrapSession and
+	// UnwrapSession, so Config must not set them.
+	SessionTicketKeyRotation time.Duration
+	// EnableHTTP3 also serves HTTP/3 (QUIC) on the UDP port matching the TLS port, with the same handler
+	// and TLS config, and advertises it on the TLS responses with an Alt-Svc header. It requires TCP.
+	EnableHTTP3 bool
+	// RedirectHTTP makes the autocert port 80 server answer non-challenge requests
+	// with a permanent redirect to the HTTPS equivalent instead of autocert's default.
+	RedirectHTTP bool
+}
+
+type ServerTLSAutoCert struct {
+	CacheDir string
+	Domains  []string
+	Email    string
+	// AcceptTOS accepts the ACME CA's Terms of Service on your behalf.
+	// Either AcceptTOS or Prompt must be set for autocert mode to start.
+	AcceptTOS bool
+	// Prompt is called with the Terms of Service URL and reports whether it is accepted.
+	// It takes precedence over AcceptTOS.
+	Prompt func(tosURL string) bool
+	// DirectoryURL is the ACME directory, e.g. Let's Encrypt staging or a local Pebble.
+	// When empty, Let's Encrypt production is used.
+	DirectoryURL string
+	// RenewBefore is how long before expiry certificates are renewed, e.g. less for short-lived
+	// certificates, in every autocert mode including DNS-01. When zero, certificates are renewed 30
+	// days before expiry, and DNS-01 renews short-lived ones a third of their lifetime before expiry.
+	// Otherwise it must be longer than an hour.
+	RenewBefore time.Duration
+	// HostPolicy decides at request time which hosts may obtain certificates, for both
+	// GetCertificate and the HTTP challenge server. When nil, only Domains are allowed.
+	HostPolicy autocert.HostPolicy
+	// Cache stores certificates, e.g. in a store shared between replicas.
+	// When nil, certificates are cached on disk in CacheDir.
+	Cache autocert.Cache
+	// ChallengePort is the port of the ACME HTTP challenge server. Defaults to DefaultACMEChallengePort.
+	ChallengePort string
+	// ChallengeTimeouts are the timeouts of the ACME HTTP challenge server, independent of Server.Timeouts.
+	// Its requests are tiny, so short timeouts limit slow clients on port 80. Zero fields fall back to HTTPServerTimeOut.
+	ChallengeTimeouts Timeouts
+	// DisableHTTPChallenge skips the ACME HTTP challenge server entirely, so nothing binds ChallengePort.
+	// Certificates can still be obtained through TLS-ALPN-01, which is answered by the main TLS listener.
+	DisableHTTPChallenge bool
+	// PreObtain obtains the certificates for all Domains before the server starts listening, so the
+	// first handshakes do not wait on the ACME CA. While the CA is unreachable, it is retried with
+	// backoff; Run fails if a certificate cannot be obtained. It requires the HTTP challenge.
+	PreObtain bool
+	// PreObtainAttempts bounds the attempts to reach the CA. Defaults to DefaultPreObtainAttempts.
+	PreObtainAttempts int
+	// PreObtainBackoff is the wait after the first failed attempt, doubled after each further one.
+	// Defaults to DefaultPreObtainBackoff.
+	PreObtainBackoff time.Duration
+	// DNSProvider publishes the DNS-01 challenge records used by RunAutoCertDNS, e.g. for wildcard domains.
+	DNSProvider DNSProvider
+}
+
+type UnsupportedTLSModeError struct {
+	Mode string
+}
+
+func (err UnsupportedTLSModeError) Error() string {
+	return fmt.Sprintf("TLS mode %q is not supported", err.Mode)
+}
+
+type UnsupportedTLSVersionError struct {
+	Version uint16
+}
+
+func (err UnsupportedTLSVersionError) Error() string {
+	return fmt.Sprintf("TLS version %s is not supported", tls.VersionName(err.Version))
+}
+
+// TLSCertificateError is returned when the manual TLS key pair cannot be loaded.
+// Err is the underlying os or tls error.
+type TLSCertificateError struct {
+	CertFile string
+	KeyFile  string
+	Err      error
+}
+
+func (err TLSCertificateError) Error() string {
+	return fmt.Sprintf("failed to load TLS certificate %q with key %q: %v", err.CertFile, err.KeyFile, err.Err)
+}
+
+func (err TLSCertificateError) Unwrap() error {
+	return err.Err
+}
+
+// ListenError is returned when binding the listen address fails. Err is the underlying error,
+// so errors.Is(err, syscall.EADDRINUSE) detects an address in use.
+type ListenError struct {
+	Addr string
+	Err  error
+}
+
+func (err ListenError) Error() string {
+	return fmt.Sprintf("failed to listen on %s: %v", err.Addr, err.Err)
+}
+
+func (err ListenError) Unwrap() error {
+	return err.Err
+}
+
+// InvalidPortError is returned by Validate when Port is neither a number from 0 to 65535 nor a
+// service name known to the resolver, such as "http" or "https".
+type InvalidPortError struct {
+	Port string
+}
+
+func (err InvalidPortError) Error() string {
+	return fmt.Sprintf("port %q is not a number from 0 to 65535 or a known service name", err.Port)
+}
+
+// SignalError is the cause of the shutdown when RunWithSignals receives Signal.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (err SignalError) Error() string {
+	return "received signal " + err.Signal.String()
+}
+
+func (server *Server) logger() *slog.Logger {
+	if server.Logger != nil {
+		return server.Logger
+	}
+
+	return discardLogger
+}
+
+// Ready returns a channel that receives the bound address once the server is
+// listening, before the first connection is accepted. The channel is closed
+// when the server stops, so receiving without a value means it failed to bind.
+// Call Ready before Run to observe a failed start.
+func (server *Server) Ready() <-chan net.Addr {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.ready == nil {
+		server.ready = make(chan net.Addr, 1)
+	}
+
+	return server.ready
+}
+
+// Addr returns the address the server is listening on, or nil if it is not running.
+func (server *Server) Addr() net.Addr {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.addr
+}
+
+func (server *Server) markReady(ctx context.Context, addr net.Addr) {
+	// The run is stopping, so it will not serve addr.
+	if ctx.Err() != nil {
+		return
+	}
+
+	if server.OnReady != nil {
+		server.OnReady(addr)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.ready == nil {
+		server.ready = make(chan net.Addr, 1)
+	}
+
+	server.addr = addr
+
+	select {
+	case server.ready <- addr:
+	default:
+	}
+}
+
+// serveGateKey is the context key of a function serveTLS calls once its listener is bound and the
+// readiness check passed, before serving, so RunHTTPAndTLS serves its plain listener only then.
+type serveGateKey struct{}
+
+// openServeGate calls the function stored in ctx under serveGateKey, if any.
+func openServeGate(ctx context.Context) {
+	openGate, ok := ctx.Value(serveGateKey{}).(func())
+	if ok {
+		openGate()
+	}
+}
+
+// checkReadiness runs ReadinessCheck, if set.
+func (server *Server) checkReadiness(ctx context.Context) error {
+	if server.ReadinessCheck == nil {
+		return nil
+	}
+
+	err := server.ReadinessCheck(ctx)
+	if err != nil {
+		return fmt.Errorf("readiness check failed: %w", err)
+	}
+
+	return nil
+}
+
+// StopCause returns why the last graceful shutdown started: context.Cause of the context passed to
+// Run, e.g. context.Canceled, context.DeadlineExceeded, a cause given to context.WithCancelCause or
+// a *SignalError from RunWithSignals. It is nil while the server runs and when the last run stopped on an error.
+func (server *Server) StopCause() error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.stopCause
+}
+
+func (server *Server) markStopped() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.ready != nil {
+		close(server.ready)
+		server.ready = nil
+	}
+
+	server.addr = nil
+	server.httpServer = nil
+	server.started = true
+}
+
+func (server *Server) network() string {
+	if server.Network == "" {
+		return DefaultNetwork
+	}
+
+	return server.Network
+}
+
+// tcpNetwork is the network for TCP-only listeners such as the ACME challenge server.
+// It follows an IPv4/IPv6-only Network and falls back to NetworkTCP otherwise.
+func (server *Server) tcpNetwork() string {
+	switch network := server.network(); network {
+	case NetworkTCP4, NetworkTCP6:
+		return network
+	default:
+		return NetworkTCP
+	}
+}
+
+// listenConfig returns the ListenConfig of all listeners. There is no option for SO_REUSEADDR: the
+// net package already sets it on TCP listeners on Unix, so a restarted server can bind its port
+// while connections of the previous one are in TIME_WAIT.
+func (server *Server) listenConfig() *net.ListenConfig {
+	listenConfig := &net.ListenConfig{}
+	if server.ListenConfig != nil {
+		*listenConfig = *server.ListenConfig
+	}
+
+	if server.KeepAlivePeriod != 0 {
+		listenConfig.KeepAlive = server.KeepAlivePeriod
+	}
+
+	return listenConfig
+}
+
+// listen binds addr for a server of the application and applies the listener options to it.
+func (server *Server) listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	listener, err := server.bind(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.wrapListener(listener), nil
+}
+
+// bind binds addr without the listener options, e.g. for the ACME challenge server, which the CA
+// reaches directly rather than through the proxy.
+func (server *Server) bind(ctx context.Context, network, addr string) (net.Listener, error) {
+	if network == NetworkUnix {
+		err := removeStaleSocket(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := server.listenConfig().Listen(ctx, network, addr)
+	if err != nil {
+		return nil, &ListenError{Addr: addr, Err: err}
+	}
+
+	if network == NetworkUnix {
+		err = os.Chmod(addr, UnixSocketMode)
+		if err != nil {
+			_ = listener.Close()
+
+			return nil, fmt.Errorf("failed to set permissions on %s: %w", addr, err)
+		}
+	}
+
+	return listener, nil
+}
+
+// wrapListener applies the listener options, such as the connection limit and the PROXY protocol,
+// to a bound listener.
+func (server *Server) wrapListener(listener net.Listener) net.Listener {
+	if server.MaxConnections > 0 {
+		listener = netutil.LimitListener(listener, server.MaxConnections)
+	}
+
+	if server.EnableProxyProtocol {
+		proxyListener := &proxyproto.Listener{Listener: listener}
+
+		if server.RequireProxyHeader {
+			proxyListener.ConnPolicy = func(proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+				return proxyproto.REQUIRE, nil
+			}
+		}
+
+		listener = proxyListener
+	}
+
+	return listener
+}
+
+// removeStaleSocket removes a socket file left behind by a previous run. Other files are kept.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	if info.Mode().Type() != os.ModeSocket {
+		return nil
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// SetHandler atomically replaces the handler of the running server without touching the listener.
+// Requests already being handled finish with the previous handler. It does not affect RunMulti.
+func (server *Server) SetHandler(httpHandler http.Handler) {
+	server.handler.Store(&httpHandler)
+}
+
+// dispatch serves a request with the current handler.
+func (server *Server) dispatch(w http.ResponseWriter, r *http.Request) {
+	(*server.handler.Load()).ServeHTTP(w, r)
+}
+
+// RegisterOnShutdown registers f to be called by the underlying http.Server when it shuts down,
+// e.g. to notify hijacked WebSocket connections. Callbacks apply to the running server and to later runs.
+func (server *Server) RegisterOnShutdown(f func()) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.shutdownFuncs = append(server.shutdownFuncs, f)
+
+	if server.httpServer != nil {
+		server.httpServer.RegisterOnShutdown(f)
+	}
+}
+
+func (server *Server) shutdownTimeout() time.Duration {
+	if server.ShutdownTimeout > 0 {
+		return server.ShutdownTimeout
+	}
+
+	return ShutdownTimeout
+}
+
+// withMaxLifetime returns a copy of ctx canceled with ErrMaxLifetimeReached after MaxLifetime,
+// and a function releasing it.
+func (server *Server) withMaxLifetime(ctx context.Context) (context.Context, func()) {
+	if server.MaxLifetime <= 0 {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(server.MaxLifetime, func() { cancel(ErrMaxLifetimeReached) })
+
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// shutdownContext returns the context bounding graceful shutdown of a server run with ctx.
+func (server *Server) shutdownContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	parent := server.ShutdownBaseContext
+	if parent == nil {
+		parent = context.WithoutCancel(ctx)
+	}
+
+	return context.WithTimeout(parent, server.shutdownTimeout())
+}
+
+// EffectiveTLSMode reports whether Run serves TLS and in which mode once defaults are applied,
+// e.g. for a startup banner. The mode is empty when TLS is disabled. It does not validate the mode.
+func (server *Server) EffectiveTLSMode() (bool, string) {
+	if !server.TLS.Enabled {
+		return false, ""
+	}
+
+	return true, server.tlsMode()
+}
+
+func (server *Server) tlsMode() string {
+	if server.TLS.Mode == "" {
+		return DefaultTLSMode
+	}
+
+	return server.TLS.Mode
+}
+
+func timeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+
+	return HTTPServerTimeOut
+}
+
+// readHeaderTimeout is the header read timeout of a server configured with readHeader.
+func (server *Server) readHeaderTimeout(readHeader time.Duration) time.Duration {
+	if readHeader == 0 && server.SlowlorisProtection {
+		return SlowlorisReadHeaderTimeout
+	}
+
+	return timeoutOrDefault(readHeader)
+}
+
+// newPrimaryHTTPServer creates the http.Server serving the application handler, or completes the
+// one passed to RunServer.
+func (server *Server) newPrimaryHTTPServer(ctx context.Context, addr string, httpHandler http.Handler) *http.Server {
+	server.handler.Store(&httpHandler)
+	server.draining.Store(false)
+	server.reloadedTimeouts.Store(nil)
+
+	server.mu.Lock()
+	httpServer := server.baseHTTPServer
+	server.mu.Unlock()
+
+	if httpServer == nil {
+		httpServer = &http.Server{}
+	}
+
+	wrappedHandler := server.reloadedTimeoutsHandler(server.wrapHandler(http.HandlerFunc(server.dispatch)))
+	server.initHTTPServer(ctx, httpServer, addr, wrappedHandler, server.Timeouts)
+	server.applyStreamingMode(httpServer)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	for _, f := range server.shutdownFuncs {
+		httpServer.RegisterOnShutdown(f)
+	}
+
+	server.httpServer = httpServer
+	server.stopCause = nil
+	server.started = true
+
+	return httpServer
+}
+
+func (server *Server) newHTTPServer(ctx context.Context, addr string, httpHandler http.Handler, timeouts Timeouts) *http.Server {
+	httpServer := &http.Server{}
+	server.initHTTPServer(ctx, httpServer, addr, httpHandler, timeouts)
+
+	return httpServer
+}
+
+// initHTTPServer sets the handler of httpServer, fills its unset fields with the server's settings
+// and tracks its connections. A ConnState already set is called after the tracking.
+func (server *Server) initHTTPServer(ctx context.Context, httpServer *http.Server, addr string, httpHandler http.Handler, timeouts Timeouts) {
+	tracker := &connTracker{next: server.ConnState}
+	if httpServer.ConnState != nil {
+		tracker.next = httpServer.ConnState
+	}
+
+	httpServer.Handler = httpHandler
+	httpServer.ConnState = tracker.connState
+
+	if httpServer.Addr == "" {
+		httpServer.Addr = addr
+	}
+
+	if httpServer.ReadTimeout == 0 {
+		httpServer.ReadTimeout = timeoutOrDefault(timeouts.Read)
+	}
+
+	if httpServer.ReadHeaderTimeout == 0 {
+		httpServer.ReadHeaderTimeout = server.readHeaderTimeout(timeouts.ReadHeader)
+	}
+
+	if httpServer.WriteTimeout == 0 {
+		httpServer.WriteTimeout = timeoutOrDefault(timeouts.Write)
+	}
+
+	if httpServer.IdleTimeout == 0 {
+		httpServer.IdleTimeout = timeoutOrDefault(timeouts.Idle)
+	}
+
+	if httpServer.MaxHeaderBytes == 0 {
+		httpServer.MaxHeaderBytes = server.MaxHeaderBytes
+	}
+
+	if httpServer.ErrorLog == nil {
+		httpServer.ErrorLog = server.errorLog()
+	}
+
+	if httpServer.ConnContext == nil {
+		httpServer.ConnContext = server.ConnContext
+	}
+
+	if httpServer.BaseContext == nil {
+		httpServer.BaseContext = server.BaseContext
+	}
+
+	if server.DisableGeneralOptionsHandler {
+		httpServer.DisableGeneralOptionsHandler = true
+	}
+
+	if httpServer.BaseContext == nil {
+		baseCtx := server.requestBaseContext(ctx)
+		httpServer.BaseContext = func(_ net.Listener) context.Context { return baseCtx }
+	}
+
+	if server.DisableKeepAlives {
+		httpServer.SetKeepAlivesEnabled(false)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// Requests get a copy, so handlers read the configuration without racing with Reload.
+	view := server.Clone()
+	baseContext := httpServer.BaseContext
+	httpServer.BaseContext = func(listener net.Listener) context.Context {
+		return context.WithValue(baseContext(listener), serverContextKey{}, view)
+	}
+
+	if server.connTrackers == nil {
+		server.connTrackers = make(map[*http.Server]*connTracker)
+	}
+
+	server.connTrackers[httpServer] = tracker
+}
+
+// serverContextKey is the context key of the server view returned by FromContext.
+type serverContextKey struct{}
+
+// FromContext returns the server that is serving the request with context ctx, e.g. to use its
+// Logger or TLS.AutoCert.Domains in a handler, over HTTP/1.1, HTTP/2 and HTTP/3 alike, as they share
+// the base context. It is a copy made with Clone when the server started, so reading it does not
+// race with the running server, and changing it has no effect.
+func FromContext(ctx context.Context) (*Server, bool) {
+	server, ok := ctx.Value(serverContextKey{}).(*Server)
+
+	return server, ok
+}
+
+// requestBaseContext returns the default base context of requests of a server run with ctx. With
+// PreShutdownDelay, it is canceled the delay after ctx, so requests served meanwhile are not.
+func (server *Server) requestBaseContext(ctx context.Context) context.Context {
+	if server.PreShutdownDelay <= 0 {
+		return ctx
+	}
+
+	baseCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+
+	context.AfterFunc(ctx, func() {
+		cause := context.Cause(ctx)
+		if errors.Is(cause, errRunStopped) {
+			cancel(cause)
+
+			return
+		}
+
+		time.AfterFunc(server.PreShutdownDelay, func() { cancel(cause) })
+	})
+
+	return baseCtx
+}
+
+// warnLowWriteTimeout logs a warning when Timeouts.Write is below LowTLSWriteTimeout on a TLS server.
+func (server *Server) warnLowWriteTimeout(ctx context.Context) {
+	if server.StreamingMode || server.Timeouts.Write <= 0 || server.Timeouts.Write >= LowTLSWriteTimeout {
+		return
+	}
+
+	server.logger().WarnContext(ctx, "write timeout is low for TLS, handshakes on slow networks may be cut off",
+		"timeout", server.Timeouts.Write, "recommended", LowTLSWriteTimeout)
+}
+
+// applyStreamingMode lifts the read and write timeouts of an application httpServer in StreamingMode.
+func (server *Server) applyStreamingMode(httpServer *http.Server) {
+	if !server.StreamingMode {
+		return
+	}
+
+	httpServer.ReadTimeout = 0
+	httpServer.WriteTimeout = 0
+
+	if server.Timeouts.Idle <= 0 {
+		httpServer.IdleTimeout = StreamingIdleTimeout
+	}
+}
+
+// connTracker counts the open connections of an http.Server and forwards state changes to next.
+type connTracker struct {
+	open atomic.Int64
+	next func(net.Conn, http.ConnState)
+}
+
+func (tracker *connTracker) connState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		tracker.open.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		tracker.open.Add(-1)
+	case http.StateActive, http.StateIdle:
+	}
+
+	if tracker.next != nil {
+		tracker.next(conn, state)
+	}
+}
+
+// openConns reports the open, non-hijacked connections of httpServer.
+func (server *Server) openConns(httpServer *http.Server) int64 {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	tracker, ok := server.connTrackers[httpServer]
+	if !ok {
+		return 0
+	}
+
+	return tracker.open.Load()
+}
+
+func (server *Server) untrackConns(httpServer *http.Server) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	delete(server.connTrackers, httpServer)
+}
+
+// Run starts the HTTP server.
+func (server *Server) Run(ctx context.Context, httpHandler http.Handler) error {
+	return server.run(ctx, "", httpHandler)
+}
+
+// RunServer runs httpServer, built by the caller for full control over http.Server, like Run: it is
+// served with TLS according to the TLS settings, shut down gracefully and its handler is wrapped with
+// the handler options. Only fields left unset are filled in, Addr defaults to Host and Port and
+// a nil Handler serves http.DefaultServeMux. A TLSConfig takes the place of TLS.Config: its settings
+// are kept and only certificates and protocols are added to a clone of it. An http.Server cannot be
+// served again after it is shut down.
+func (server *Server) RunServer(ctx context.Context, httpServer *http.Server) error {
+	httpHandler := httpServer.Handler
+	if httpHandler == nil {
+		httpHandler = http.DefaultServeMux
+	}
+
+	server.mu.Lock()
+	server.baseHTTPServer = httpServer
+	server.mu.Unlock()
+
+	defer func() {
+		server.mu.Lock()
+		server.baseHTTPServer = nil
+		server.mu.Unlock()
+	}()
+
+	return server.run(ctx, httpServer.Addr, httpHandler)
+}
+
+// run is Run on addr, or on the address from Host and Port when addr is empty.
+func (server *Server) run(ctx context.Context, addr string, httpHandler http.Handler) error {
+	defer server.markStopped()
+
+	if server.Port == "" {
+		server.Port = DefaultPort
+	}
+
+	if server.TLS.Mode == "" {
+		server.TLS.Mode = DefaultTLSMode
+	}
+
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
+
+	if addr == "" {
+		addr = net.JoinHostPort(server.Host, server.Port)
+		if server.network() == NetworkUnix {
+			addr = server.Host
+		}
+	}
+
+	if server.TLS.Enabled {
+		server.logger().DebugContext(ctx, "TLS is enabled")
+
+		return server.runTLS(ctx, addr, httpHandler)
+	}
+
+	return server.RunUnsecured(ctx, addr, httpHandler)
+}
+
+// runTLS serves httpHandler on addr with the configured TLS mode.
+func (server *Server) runTLS(ctx context.Context, addr string, httpHandler http.Handler) error {
+	switch server.tlsMode() {
+	case TLSModeAutoCert, TLSModeAutoCertTLSALPN:
+		return server.RunAutoCert(ctx, addr, httpHandler)
+	case TLSModeManual:
+		return server.RunManualTLS(ctx, addr, httpHandler)
+	default:
+		return &UnsupportedTLSModeError{Mode: server.TLS.Mode}
+	}
+}
+
+// Validate checks the configuration for invariants that would otherwise only fail at serve time.
+// All violations are reported together. Every Run method calls Validate before binding anything.
+func (server *Server) Validate() error {
+	var errs []error
+
+	if server.Port != "" && server.network() != NetworkUnix && !validPort(server.Port) {
+		errs = append(errs, &InvalidPortError{Port: server.Port})
+	}
+
+	if server.IPFilter != nil {
+		_, err := server.IPFilter.parse()
+		errs = append(errs, err)
+	}
+
+	_, err := parsePrefixes("TrustedProxies", server.TrustedProxies)
+	errs = append(errs, err)
+
+	if server.RateLimit != nil && !(server.RateLimit.RequestsPerSecond > 0) {
+		errs = append(errs, ErrInvalidRateLimit)
+	}
+
+	if server.TLS.Enabled {
+		errs = append(errs, server.validateTLS()...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validPort reports whether port is a number from 0 to 65535 or a named port, such as "https",
+// that the resolver looks up when binding.
+func validPort(port string) bool {
+	number, err := strconv.Atoi(port)
+	if err == nil {
+		return number >= 0 && number <= 65535
+	}
+
+	_, err = net.LookupPort(NetworkTCP, port)
+
+	return err == nil
+}
+
+func (server *Server) validateTLS() []error {
+	var errs []error
+
+	switch server.tlsMode() {
+	case TLSModeAutoCert, TLSModeAutoCertTLSALPN:
+		errs = append(errs, server.validateAutoCert()...)
+	case TLSModeManual:
+		errs = append(errs, server.validateManualTLS()...)
+	default:
+		errs = append(errs, &UnsupportedTLSModeError{Mode: server.TLS.Mode})
+	}
+
+	if server.TLS.EnableHTTP3 && server.network() == NetworkUnix {
+		errs = append(errs, ErrHTTP3RequiresTCP)
+	}
+
+	if server.TLS.MinVersion != 0 && !slices.Contains(supportedTLSVersions, server.TLS.MinVersion) {
+		errs = append(errs, &UnsupportedTLSVersionError{Version: server.TLS.MinVersion})
+	}
+
+	base := server.baseTLSConfig()
+	if server.TLS.SessionTicketKeyRotation > 0 && base != nil && (base.WrapSession != nil || base.UnwrapSession != nil) {
+		errs = append(errs, ErrSessionTicketRotationConflict)
+	}
+
+	return errs
+}
+
+func (server *Server) validateAutoCert() []error {
+	autoCert := server.TLS.AutoCert
+	if autoCert == nil {
+		return []error{ErrAutoCertConfigMissing}
+	}
+
+	var errs []error
+
+	// An empty whitelist would reject every handshake.
+	if len(autoCert.Domains) == 0 && autoCert.HostPolicy == nil {
+		errs = append(errs, ErrNoDomains)
+	}
+
+	if autoCert.Prompt == nil && !autoCert.AcceptTOS {
+		errs = append(errs, ErrTOSNotAccepted)
+	}
+
+	if autoCert.PreObtain && server.httpChallengeDisabled() {
+		errs = append(errs, ErrPreObtainRequiresHTTPChallenge)
+	}
+
+	if !validRenewBefore(autoCert.RenewBefore) {
+		errs = append(errs, ErrInvalidRenewBefore)
+	}
+
+	return errs
+}
+
+// validRenewBefore reports whether renewBefore is zero or longer than an hour. autocert treats
+// values up to an hour as unset.
+func validRenewBefore(renewBefore time.Duration) bool {
+	return renewBefore == 0 || renewBefore > time.Hour
+}
+
+func (server *Server) validateManualTLS() []error {
+	hasCertFile, hasKeyFile := server.TLS.CertFile != "", server.TLS.KeyFile != ""
+	hasConfigCertificates := server.TLS.Config != nil && hasCertificates(server.TLS.Config)
+
+	switch {
+	case hasCertFile != hasKeyFile:
+		return []error{ErrIncompleteKeyPair}
+	case !hasCertFile && len(server.TLS.Certificates) == 0 && !hasConfigCertificates:
+		return []error{ErrNoCertificates}
+	default:
+		return nil
+	}
+}
+
+// RunWithSignals runs the server like Run and shuts it down gracefully when one of the given
+// signals is received, with a *SignalError as StopCause. It defaults to os.Interrupt and
+// syscall.SIGTERM when no signals are given.
+func (server *Server) RunWithSignals(ctx context.Context, httpHandler http.Handler, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, signals...)
+
+	defer signal.Stop(signalCh)
+
+	go func() {
+		select {
+		case sig := <-signalCh:
+			cancel(&SignalError{Signal: sig})
+		case <-ctx.Done():
+		}
+	}()
+
+	return server.Run(ctx, httpHandler)
+}
+
+func (server *Server) newAcmeChallengeHandler(autocertManager *autocert.Manager, tlsAddr string) http.Handler {
+	var fallback http.Handler
+
+	if server.TLS.RedirectHTTP {
+		_, port, _ := net.SplitHostPort(tlsAddr)
+		fallback = httpsRedirectHandler(port)
+	}
+
+	return autocertManager.HTTPHandler(fallback) // serves /.well-known/acme-challenge/*
+}
+
+// httpsRedirectHandler redirects every request to the HTTPS equivalent on port, keeping path and query.
+func httpsRedirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+func hostRedirectHandler(targetHost string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://"+targetHost+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+func (server *Server) acmeChallengeAddr() string {
+	port := server.TLS.AutoCert.ChallengePort
+	if port == "" {
+		port = DefaultACMEChallengePort
+	}
+
+	return ":" + port
+}
+
+// hasCertificates reports whether a TLS config already provides certificates, e.g. one passed in TLS.Config.
+func hasCertificates(tlsConfig *tls.Config) bool {
+	return tlsConfig.GetCertificate != nil || len(tlsConfig.Certificates) > 0
+}
+
+// configureManualTLS loads the manual certificates and serves them, unless TLS.Config already provides some.
+func (server *Server) configureManualTLS(ctx context.Context, tlsConfig *tls.Config) error {
+	if hasCertificates(tlsConfig) {
+		return nil
+	}
+
+	refreshAt, err := server.reloadCertificates(ctx)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig.GetCertificate = server.getCertificate
+
+	if server.TLS.ReloadOnSIGHUP {
+		// Subscribe before serving: until then, SIGHUP terminates the process.
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+
+		go server.reloadCertificatesOnSIGHUP(ctx, hangups)
+	}
+
+	if server.TLS.EnableOCSPStapling {
+		go server.refreshOCSPStaples(ctx, refreshAt)
+	}
+
+	return nil
+}
+
+// configureAutocertTLS serves autocert certificates, unless TLS.Config already provides some, and
+// advertises the acme-tls/1 protocol, without which TLS-ALPN-01 validation requests fail the handshake.
+func configureAutocertTLS(tlsConfig *tls.Config, autocertManager *autocert.Manager) {
+	if !hasCertificates(tlsConfig) {
+		tlsConfig.GetCertificate = autocertManager.GetCertificate
+	}
+
+	if !slices.Contains(tlsConfig.NextProtos, acme.ALPNProto) {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	}
+}
+
+// httpChallengeDisabled reports whether the ACME HTTP challenge server must not be started.
+// Without it, the autocert manager's HTTPHandler is never installed and it only tries TLS-ALPN-01.
+func (server *Server) httpChallengeDisabled() bool {
+	return server.TLS.AutoCert.DisableHTTPChallenge || server.tlsMode() == TLSModeAutoCertTLSALPN
+}
+
+// preObtainCertificates obtains the certificates for all domains through autocertManager if
+// AutoCert.PreObtain is set. The challenge server must be running.
+//
+// autocert remembers a failed order for a minute, so retrying GetCertificate sooner is pointless.
+// Instead, domains without a cached certificate wait for the ACME directory to be reachable, with
+// exponential backoff, before their one GetCertificate call.
+func (server *Server) preObtainCertificates(ctx context.Context, autocertManager *autocert.Manager) error {
+	if !server.TLS.AutoCert.PreObtain {
+		return nil
+	}
+
+	if server.httpChallengeDisabled() {
+		return ErrPreObtainRequiresHTTPChallenge
+	}
+
+	reachable := false
+
+	for _, domain := range server.TLS.AutoCert.Domains {
+		_, err := autocertManager.Cache.Get(ctx, domain)
+		if err != nil && !reachable {
+			err = server.waitForACMEDirectory(ctx, autocertManager)
+			if err != nil {
+				return fmt.Errorf("failed to obtain certificate for %s: %w", domain, err)
+			}
+
+			reachable = true
+		}
+
+		// Offer ECDSA like modern clients do, so the certificate they get is the one obtained here.
+		_, err = autocertManager.GetCertificate(&tls.ClientHelloInfo{
+			ServerName:       domain,
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedCurves:  []tls.CurveID{tls.CurveP256},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to obtain certificate for %s: %w", domain, err)
+		}
+
+		server.logger().DebugContext(ctx, "certificate obtained", "domain", domain)
+	}
+
+	return nil
+}
+
+// waitForACMEDirectory fetches the ACME directory until it succeeds, up to AutoCert.PreObtainAttempts
+// times with AutoCert.PreObtainBackoff doubling between attempts.
+func (server *Server) waitForACMEDirectory(ctx context.Context, autocertManager *autocert.Manager) error {
+	attempts := server.TLS.AutoCert.PreObtainAttempts
+	if attempts <= 0 {
+		attempts = DefaultPreObtainAttempts
+	}
+
+	wait := server.TLS.AutoCert.PreObtainBackoff
+	if wait <= 0 {
+		wait = DefaultPreObtainBackoff
+	}
+
+	client := &acme.Client{DirectoryURL: autocert.DefaultACMEDirectory}
+	if autocertManager.Client != nil {
+		client = autocertManager.Client
+	}
+
+	for attempt := 1; ; attempt++ {
+		_, err := client.Discover(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == attempts {
+			return fmt.Errorf("ACME directory unreachable after %d attempts: %w", attempts, err)
+		}
+
+		server.logger().WarnContext(ctx, "ACME directory unreachable, retrying",
+			"attempt", attempt, "retryIn", wait, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+	}
+}
+
+func (server *Server) checkAcmeChallengePort(tlsAddr string) error {
+	if server.httpChallengeDisabled() {
+		return nil
+	}
+
+	_, challengePort, _ := net.SplitHostPort(server.acmeChallengeAddr())
+
+	_, tlsPort, err := net.SplitHostPort(tlsAddr)
+	if err == nil && tlsPort == challengePort {
+		return fmt.Errorf("%w: both use port %s", ErrChallengePortConflict, tlsPort)
+	}
+
+	return nil
+}
+
+// startAcmeChallengeServer binds the ACME HTTP challenge server and serves it in the background until
+// ctx is done or the returned function is called, which shuts it down gracefully, waits until its port
+// is released and returns the shutdown error. It may be called more than once.
+// A bind failure is returned so that the caller can fail fast instead of silently missing challenges.
+func (server *Server) startAcmeChallengeServer(ctx context.Context, autocertManager *autocert.Manager, tlsAddr string) (func() error, error) {
+	if server.httpChallengeDisabled() {
+		server.logger().DebugContext(ctx, "ACME HTTP challenge server is disabled")
+
+		return func() error { return nil }, nil
+	}
+
+	addr := server.acmeChallengeAddr()
+
+	listener, err := server.bind(ctx, server.tcpNetwork(), addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start ACME challenge server: %w", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	var (
+		wg          sync.WaitGroup
+		shutdownErr error
+	)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		shutdownErr = server.runAcmeChallengeServer(ctx, autocertManager, tlsAddr, listener)
+	}()
+
+	return sync.OnceValue(func() error {
+		cancel(errRunStopped)
+		wg.Wait()
+
+		// Serve may not have taken over the listener yet when the server was shut down.
+		_ = listener.Close()
+
+		return shutdownErr
+	}), nil
+}
+
+// runAcmeChallengeServer serves the ACME HTTP challenge server until ctx is done and returns the error
+// of its graceful shutdown. Errors while it serves are logged and passed to OnError instead.
+func (server *Server) runAcmeChallengeServer(ctx context.Context, autocertManager *autocert.Manager, tlsAddr string, listener net.Listener) error {
+	httpHandler := server.newAcmeChallengeHandler(autocertManager, tlsAddr)
+
+	addr := server.acmeChallengeAddr()
+
+	httpServer := server.newHTTPServer(ctx, addr, httpHandler, server.TLS.AutoCert.ChallengeTimeouts)
+
+	err := server.runCancelable(ctx, httpServer, false, func() error {
+		server.logLifecycle(ctx, "HTTP (ACME challenge) listening on "+addressURL(listener.Addr().Network(), listener.Addr().String(), false))
+
+		err := httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to start ACME challenge server: %w", err)
+		}
+
+		return nil
+	})
+	if err == nil {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("ACME challenge server: %w", err)
+	}
+
+	server.logger().ErrorContext(ctx, "ACME challenge server error", "error", err)
+	server.reportError(ctx, fmt.Errorf("ACME challenge server error: %w", err))
+
+	return nil
+}
+
+func (server *Server) newAutocertManager(ctx context.Context) (*autocert.Manager, error) {
+	prompt, err := server.autocertPrompt()
+	if err != nil {
+		return nil, err
+	}
+
+	hostPolicy := server.TLS.AutoCert.HostPolicy
+	if hostPolicy == nil {
+		hostPolicy = autocert.HostWhitelist(server.TLS.AutoCert.Domains...)
+	}
+
+	autocertManager := &autocert.Manager{
+		Prompt:      prompt,
+		Cache:       server.autocertCache(ctx),
+		HostPolicy:  hostPolicy,
+		RenewBefore: server.TLS.AutoCert.RenewBefore,
+		Email:       server.TLS.AutoCert.Email,
+	}
+
+	if server.TLS.AutoCert.DirectoryURL != "" {
+		autocertManager.Client = &acme.Client{DirectoryURL: server.TLS.AutoCert.DirectoryURL}
+	}
+
+	return autocertManager, nil
+}
+
+// autocertPrompt returns the ACME Terms of Service prompt, which must be accepted explicitly.
+func (server *Server) autocertPrompt() (func(tosURL string) bool, error) {
+	if server.TLS.AutoCert.Prompt != nil {
+		return server.TLS.AutoCert.Prompt, nil
+	}
+
+	if !server.TLS.AutoCert.AcceptTOS {
+		return nil, ErrTOSNotAccepted
+	}
+
+	return autocert.AcceptTOS, nil
+}
+
+// autocertCache returns the custom cache, if set, or the directory cache.
+func (server *Server) autocertCache(ctx context.Context) autocert.Cache {
+	if server.TLS.AutoCert.Cache != nil {
+		if server.TLS.AutoCert.CacheDir != "" {
+			server.logger().DebugContext(ctx, "using custom autocert cache, ignoring cache dir", "cacheDir", server.TLS.AutoCert.CacheDir)
+		}
+
+		return server.TLS.AutoCert.Cache
+	}
+
+	return autocert.DirCache(server.TLS.AutoCert.CacheDir) // where certs are stored on disk
+}
+
+func (server *Server) newTLSConfig() (*tls.Config, error) {
+	minVersion := server.TLS.MinVersion
+	if minVersion == 0 {
+		minVersion = DefaultTLSMinVersion
+	}
+
+	if !slices.Contains(supportedTLSVersions, minVersion) {
+		return nil, &UnsupportedTLSVersionError{Version: minVersion}
+	}
+
+	tlsConfig := &tls.Config{}
+
+	base := server.baseTLSConfig()
+	if base != nil {
+		tlsConfig = base.Clone()
+	}
+
+	// MinVersion is a floor: a base config may only raise it.
+	tlsConfig.MinVersion = max(tlsConfig.MinVersion, minVersion)
+
+	if len(server.TLS.CipherSuites) > 0 {
+		tlsConfig.CipherSuites = slices.Clone(server.TLS.CipherSuites)
+	}
+
+	if len(server.TLS.CurvePreferences) > 0 {
+		tlsConfig.CurvePreferences = slices.Clone(server.TLS.CurvePreferences)
+	}
+
+	if len(server.TLS.SessionTicketKeys) > 0 {
+		tlsConfig.SetSessionTicketKeys(server.TLS.SessionTicketKeys)
+	}
+
+	if server.TLS.ClientCAFile != "" {
+		clientCAs, err := loadCertPool(server.TLS.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = server.TLS.ClientAuth
+
+		if tlsConfig.ClientAuth == tls.NoClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// baseTLSConfig returns the config the TLS config is built on: the TLSConfig of the http.Server
+// passed to RunServer when it has one, otherwise TLS.Config.
+func (server *Server) baseTLSConfig() *tls.Config {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.baseHTTPServer != nil && server.baseHTTPServer.TLSConfig != nil {
+		return server.baseHTTPServer.TLSConfig
+	}
+
+	return server.TLS.Config
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("failed to parse client CA file %s: no PEM certificates found", file)
+	}
+
+	return pool, nil
+}
+
+// manualCertificates loads CertFile/KeyFile, if set, followed by Certificates.
+// The first certificate is served when no other matches the client's SNI.
+func (server *Server) manualCertificates() ([]tls.Certificate, error) {
+	// Reload may change the files and certificates while the server runs.
+	server.mu.Lock()
+	certFile, keyFile, extra := server.TLS.CertFile, server.TLS.KeyFile, server.TLS.Certificates
+	server.mu.Unlock()
+
+	certificates := make([]tls.Certificate, 0, len(extra)+1)
+
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, &TLSCertificateError{CertFile: certFile, KeyFile: keyFile, Err: err}
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	certificates = append(certificates, extra...)
+
+	if len(certificates) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	return certificates, nil
+}
+
+// ReloadCertificates reloads the manual TLS certificates from CertFile/KeyFile and Certificates.
+// New handshakes use the reloaded certificates, existing connections keep theirs.
+// On failure the previously loaded certificates stay in use.
+func (server *Server) ReloadCertificates() error {
+	_, err := server.reloadCertificates(context.Background())
+
+	return err
+}
+
+// reloadCertificates loads and stores the manual certificates, stapling OCSP responses if enabled.
+// It returns when the staples should be refreshed.
+func (server *Server) reloadCertificates(ctx context.Context) (time.Time, error) {
+	certificates, err := server.manualCertificates()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var refreshAt time.Time
+
+	if server.TLS.EnableOCSPStapling {
+		certificates, refreshAt = server.stapleOCSP(ctx, certificates)
+	}
+
+	server.certificates.Store(&certificates)
+
+	return refreshAt, nil
+}
+
+// getCertificate returns the loaded certificate matching the client hello, or the first one.
+func (server *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificates := server.certificates.Load()
+	if certificates == nil || len(*certificates) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	for i := range *certificates {
+		if hello.SupportsCertificate(&(*certificates)[i]) == nil {
+			return &(*certificates)[i], nil
+		}
+	}
+
+	return &(*certificates)[0], nil
+}
+
+// reloadCertificatesOnSIGHUP reloads the manual certificates for each signal received on hangups
+// until ctx is done, then stops the delivery of signals to hangups.
+func (server *Server) reloadCertificatesOnSIGHUP(ctx context.Context, hangups chan os.Signal) {
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			_, err := server.reloadCertificates(ctx)
+			if err != nil {
+				server.logger().ErrorContext(ctx, "failed to reload TLS certificates", "error", err)
+				server.reportError(ctx, fmt.Errorf("failed to reload TLS certificates: %w", err))
+
+				continue
+			}
+
+			server.logger().InfoContext(ctx, "TLS certificates reloaded")
+		}
+	}
+}
+
+// RunAutoCert starts the HTTP server with automatic TLS certificates using ACME.
+func (server *Server) RunAutoCert(ctx context.Context, addr string, httpHandler http.Handler) error {
+	defer server.markStopped()
+
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, stopLifetime := server.withMaxLifetime(ctx)
+	defer stopLifetime()
+
+	err = errors.Join(server.validateAutoCert()...)
+	if err != nil {
+		return err
+	}
+
+	err = server.checkAcmeChallengePort(addr)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := server.newTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	server.warnLowWriteTimeout(ctx)
+
+	autocertManager, err := server.newAutocertManager(ctx)
+	if err != nil {
+		return err
+	}
+
+	stopAcmeChallengeServer, err := server.startAcmeChallengeServer(ctx, autocertManager, addr)
+	if err != nil {
+		return err
+	}
+
+	defer stopAcmeChallengeServer()
+
+	err = server.preObtainCertificates(ctx, autocertManager)
+	if err != nil {
+		return err
+	}
+
+	listener, err := server.listen(ctx, server.network(), addr)
+	if err != nil {
+		return fmt.Errorf("server error: failed to start TLS server: %w", err)
+	}
+
+	server.startSessionTicketKeyRotation(ctx, tlsConfig)
+
+	httpServer := server.newPrimaryHTTPServer(ctx, addr, httpHandler)
+	httpServer.TLSConfig = tlsConfig
+	configureAutocertTLS(tlsConfig, autocertManager)
+
+	h3Server := server.newHTTP3Server(ctx)
+
+	err = server.runCancelable(ctx, httpServer, true, func() error {
+		return server.serveTLS(ctx, httpServer, h3Server, listener, "domains", domainsToHTTPSAddress(server.TLS.AutoCert.Domains))
+	})
+
+	err = errors.Join(err, h3Server.shutdown(), stopAcmeChallengeServer())
+	if err != nil {
+		return fmt.Errorf("server error: %w", err)
+	}
+
+	return nil
+}
+
+// addressURL formats addr, the address of a listener on network, as the URL logged when a server
+// starts: unix:// for Unix sockets, otherwise http:// or https://. A bound TCP address always has a
+// host, with IPv6 hosts already in brackets, so addr is used as it is.
+func addressURL(network, addr string, secure bool) string {
+	if network == NetworkUnix {
+		return "unix://" + addr
+	}
+
+	if secure {
+		return "https://" + addr
+	}
+
+	return "http://" + addr
+}
+
+func domainsToHTTPSAddress(domains []string) string {
+	prefixIter := func(yield func(string) bool) {
+		for _, d := range domains {
+			if !yield("https://" + d) {
+				return
+			}
+		}
+	}
+
+	return strings.Join(slices.Collect(prefixIter), ", ")
+}
+
+// RunManualTLS starts the HTTP server with manually provided TLS certificates.
+func (server *Server) RunManualTLS(ctx context.Context, addr string, httpHandler http.Handler) error {
+	defer server.markStopped()
+
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, stopLifetime := server.withMaxLifetime(ctx)
+	defer stopLifetime()
+
+	tlsConfig, err := server.newTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	server.warnLowWriteTimeout(ctx)
+
+	listener, err := server.listen(ctx, server.network(), addr)
+	if err != nil {
+		return fmt.Errorf("server error: failed to start TLS server: %w", err)
+	}
+
+	err = server.configureManualTLS(ctx, tlsConfig)
+	if err != nil {
+		_ = listener.Close()
+
+		return err
+	}
+
+	server.startSessionTicketKeyRotation(ctx, tlsConfig)
+
+	httpServer := server.newPrimaryHTTPServer(ctx, addr, httpHandler)
+	httpServer.TLSConfig = tlsConfig
+
+	h3Server := server.newHTTP3Server(ctx)
+
+	err = server.runCancelable(ctx, httpServer, true, func() error {
+		return server.serveTLS(ctx, httpServer, h3Server, listener)
+	})
+
+	err = errors.Join(err, h3Server.shutdown())
+	if err != nil {
+		return fmt.Errorf("server error: %w", err)
+	}
+
+	return nil
+}
+
+// h2cHandler wraps httpHandler to also serve cleartext HTTP/2 when EnableH2C is set.
+func (server *Server) h2cHandler(httpHandler http.Handler) http.Handler {
+	if !server.EnableH2C {
+		return httpHandler
+	}
+
+	http2Server := server.HTTP2
+	if http2Server == nil {
+		http2Server = &http2.Server{}
+	}
+
+	return h2c.NewHandler(httpHandler, http2Server)
+}
+
+// configureHTTP2 applies the HTTP2 settings to a TLS httpServer. Without them, Go's defaults apply.
+func (server *Server) configureHTTP2(httpServer *http.Server) error {
+	if server.HTTP2 == nil {
+		return nil
+	}
+
+	err := http2.ConfigureServer(httpServer, server.HTTP2)
+	if err != nil {
+		return fmt.Errorf("failed to configure HTTP/2: %w", err)
+	}
+
+	return nil
+}
+
+// serveTLS serves httpServer over TLS on listener, and over HTTP/3 with h3Server if it is not nil,
+// once the readiness check passes. It is the run function of every TLS mode, so the server is
+// configured the same way in all of them. logArgs are added to the "starting server" message.
+func (server *Server) serveTLS(ctx context.Context, httpServer *http.Server, h3Server *http3Server, listener net.Listener, logArgs ...any) error {
+	err := server.configureHTTP2(httpServer)
+	if err != nil {
+		_ = listener.Close()
+
+		return err
+	}
+
+	server.observeTLSHandshakes(httpServer)
+
+	err = server.checkReadiness(ctx)
+	if err != nil {
+		_ = listener.Close()
+
+		return err
+	}
+
+	openServeGate(ctx)
+
+	err = h3Server.serve(httpServer, listener)
+	if err != nil {
+		_ = listener.Close()
+
+		return err
+	}
+
+	server.markReady(ctx, listener.Addr())
+
+	address := addressURL(listener.Addr().Network(), listener.Addr().String(), true)
+	server.logLifecycle(ctx, "starting server", append([]any{"address", address}, logArgs...)...)
+
+	// ServeTLS wraps the listener with tls.NewListener using httpServer.TLSConfig.
+	err = httpServer.ServeTLS(listener, "", "")
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to start TLS server: %w", err)
+	}
+
+	return nil
+}
+
+// RunUnsecured starts the HTTP server without TLS.
+func (server *Server) RunUnsecured(ctx context.Context, addr string, httpHandler http.Handler) error {
+	defer server.markStopped()
+
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, stopLifetime := server.withMaxLifetime(ctx)
+	defer stopLifetime()
+
+	listener, err := server.listen(ctx, server.network(), addr)
+	if err != nil {
+		return fmt.Errorf("server error: failed to start server: %w", err)
+	}
+
+	httpServer := server.newPrimaryHTTPServer(ctx, addr, httpHandler)
+	httpServer.Handler = server.h2cHandler(httpServer.Handler)
+
+	err = server.runCancelable(ctx, httpServer, true, func() error {
+		err := server.checkReadiness(ctx)
+		if err != nil {
+			_ = listener.Close()
+
+			return err
+		}
+
+		server.markReady(ctx, listener.Addr())
+
+		address := addressURL(listener.Addr().Network(), listener.Addr().String(), false)
+		server.logLifecycle(ctx, "starting server", "address", address)
+
+		err = httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("server error: %w", err)
+	}
 
-type ServerTLS struct {
-	Enabled  bool
-	Mode     string
-	AutoCert *ServerTLSAutoCert
-	CertFile string
-	KeyFile  string
+	return nil
 }
 
-type ServerTLSAutoCert struct {
-	CacheDir string
-	Domains  []string
-	Email    string
-}
+// RunMulti serves each handler on its own address without TLS, e.g. the app and a metrics handler,
+// under one lifecycle. All addresses are bound before any is served. When ctx is canceled or one of
+// them fails, all are shut down concurrently with the shutdown timeout, OnShutdown hooks run once and
+// the first error is returned. OnReady is called once per bound address; Ready and Addr are not used.
+// It returns ErrRunMultiTLS when TLS is enabled.
+func (server *Server) RunMulti(ctx context.Context, handlers map[string]http.Handler) error {
+	if server.TLS.Enabled {
+		return ErrRunMultiTLS
+	}
 
-type UnsupportedTLSModeError struct {
-	Mode string
-}
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
 
-func (err UnsupportedTLSModeError) Error() string {
-	return fmt.Sprintf("TLS mode %q is not supported", err.Mode)
-}
+	ctx, stopLifetime := server.withMaxLifetime(ctx)
+	defer stopLifetime()
 
-func (server *Server) logger() *slog.Logger {
-	if server.Logger != nil {
-		return server.Logger
+	listeners := make(map[string]net.Listener, len(handlers))
+
+	for addr := range handlers {
+		listener, err := server.listen(ctx, server.network(), addr)
+		if err != nil {
+			for _, listener := range listeners {
+				_ = listener.Close()
+			}
+
+			return fmt.Errorf("server error: %w", err)
+		}
+
+		listeners[addr] = listener
 	}
 
-	return discardLogger
-}
+	err = server.checkReadiness(ctx)
+	if err != nil {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
 
-// Run starts the HTTP server.
-func (server *Server) Run(ctx context.Context, httpHandler http.Handler) error {
-	if server.Port == "" {
-		server.Port = DefaultPort
+		return fmt.Errorf("server error: %w", err)
 	}
 
-	if server.TLS.Mode == "" {
-		server.TLS.Mode = DefaultTLSMode
+	if server.OnReady != nil {
+		for _, listener := range listeners {
+			server.OnReady(listener.Addr())
+		}
 	}
 
-	addr := server.Host + ":" + server.Port
+	server.draining.Store(false)
 
-	if server.TLS.Enabled {
-		server.logger().DebugContext(ctx, "TLS is enabled")
+	server.mu.Lock()
+	server.stopCause = nil
+	server.mu.Unlock()
 
-		switch server.TLS.Mode {
-		case TLSModeAutoCert:
-			return server.RunAutoCert(ctx, addr, httpHandler)
-		case TLSModeManual:
-			return server.RunManualTLS(ctx, addr, httpHandler)
-		default:
-			return &UnsupportedTLSModeError{Mode: server.TLS.Mode}
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	errCh := make(chan error, len(handlers))
+
+	var wg sync.WaitGroup
+
+	for addr, httpHandler := range handlers {
+		listener := listeners[addr]
+		httpServer := server.newHTTPServer(runCtx, addr, server.wrapHandler(httpHandler), server.Timeouts)
+		server.applyStreamingMode(httpServer)
+
+		server.mu.Lock()
+		for _, f := range server.shutdownFuncs {
+			httpServer.RegisterOnShutdown(f)
 		}
-	}
+		server.mu.Unlock()
 
-	return server.RunUnsecured(ctx, addr, httpHandler)
-}
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
 
-func (server *Server) runAcmeChallengeServer(ctx context.Context, autocertManager *autocert.Manager) {
-	httpHandler := autocertManager.HTTPHandler(nil) // serves /.well-known/acme-challenge/*
+			err := server.runCancelable(runCtx, httpServer, false, func() error {
+				address := addressURL(listener.Addr().Network(), listener.Addr().String(), false)
+				server.logLifecycle(ctx, "starting server", "address", address)
 
-	const acmeChallengePort = "80"
+				err := httpServer.Serve(listener)
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					return fmt.Errorf("failed to start server on %s: %w", addr, err)
+				}
 
-	addr := ":" + acmeChallengePort
+				return nil
+			})
+			if err != nil {
+				errCh <- err
+			}
 
-	httpServer := &http.Server{
-		Addr:              addr,
-		Handler:           httpHandler,
-		ReadTimeout:       HTTPServerTimeOut,
-		ReadHeaderTimeout: HTTPServerTimeOut,
-		WriteTimeout:      HTTPServerTimeOut,
-		IdleTimeout:       HTTPServerTimeOut,
-		BaseContext:       func(_ net.Listener) context.Context { return ctx },
+			// One server stopping brings the others down with it.
+			cancel(errRunStopped)
+		}()
 	}
 
-	err := server.runCancelable(ctx, httpServer, func() error {
-		server.logger().InfoContext(ctx, "HTTP (ACME challenge) listening on "+addr)
+	wg.Wait()
+	close(errCh)
 
-		err := httpServer.ListenAndServe()
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			return fmt.Errorf("failed to start ACME challenge server: %w", err)
-		}
+	if ctx.Err() != nil {
+		server.mu.Lock()
+		server.stopCause = context.Cause(ctx)
+		server.mu.Unlock()
+	}
 
-		return nil
-	})
+	shutdownCtx, cancelShutdown := server.shutdownContext(ctx)
+	defer cancelShutdown()
+
+	server.runShutdownHooks(shutdownCtx)
+
+	err = <-errCh
 	if err != nil {
-		server.logger().ErrorContext(ctx, "ACME challenge server error", "error", err)
+		return fmt.Errorf("server error: %w", err)
 	}
+
+	return nil
 }
 
-// RunAutoCert starts the HTTP server with automatic TLS certificates using ACME.
-func (server *Server) RunAutoCert(ctx context.Context, addr string, httpHandler http.Handler) error {
-	autocertManager := &autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Cache:      autocert.DirCache(server.TLS.AutoCert.CacheDir), // where certs are stored on disk
-		HostPolicy: autocert.HostWhitelist(server.TLS.AutoCert.Domains...),
-		Email:      server.TLS.AutoCert.Email,
+// RunHTTPRedirect starts an HTTP server on addr that answers every request with a 308 redirect
+// to https://targetHost, preserving path and query, and shuts it down gracefully when ctx is
+// canceled. It serves the redirect alone: no handler options apply, OnReady is called with its
+// address but Ready, Addr and OnShutdown hooks are left to the primary server.
+func (server *Server) RunHTTPRedirect(ctx context.Context, addr, targetHost string) error {
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
+
+	listener, err := server.listen(ctx, server.network(), addr)
+	if err != nil {
+		return fmt.Errorf("server error: %w", err)
 	}
 
-	go server.runAcmeChallengeServer(ctx, autocertManager)
+	httpServer := server.newHTTPServer(ctx, addr, hostRedirectHandler(targetHost), server.Timeouts)
 
-	httpServer := &http.Server{
-		Addr:              addr,
-		Handler:           httpHandler,
-		ReadTimeout:       HTTPServerTimeOut,
-		ReadHeaderTimeout: HTTPServerTimeOut,
-		WriteTimeout:      HTTPServerTimeOut,
-		IdleTimeout:       HTTPServerTimeOut,
-		BaseContext:       func(_ net.Listener) context.Context { return ctx },
-		TLSConfig: &tls.Config{
-			GetCertificate: autocertManager.GetCertificate,
-			MinVersion:     tls.VersionTLS12,
-		},
+	if server.OnReady != nil {
+		server.OnReady(listener.Addr())
 	}
 
-	err := server.runCancelable(ctx, httpServer, func() error {
-		address := domainsToHTTPSAddress(server.TLS.AutoCert.Domains)
-		server.logger().InfoContext(ctx, "starting server", "address", address)
+	err = server.runCancelable(ctx, httpServer, false, func() error {
+		server.logLifecycle(ctx, "HTTP redirect listening on "+addressURL(listener.Addr().Network(), listener.Addr().String(), false))
 
-		err := httpServer.ListenAndServeTLS("", "")
+		err := httpServer.Serve(listener)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			return fmt.Errorf("failed to start TLS server: %w", err)
+			return fmt.Errorf("failed to start redirect server on %s: %w", addr, err)
 		}
 
 		return nil
@@ -174,76 +2142,210 @@ func (server *Server) RunAutoCert(ctx context.Context, addr string, httpHandler
 	return nil
 }
 
-func domainsToHTTPSAddress(domains []string) string {
-	prefixIter := func(yield func(string) bool) {
-		for _, d := range domains {
-			if !yield("https://" + d) {
-				return
-			}
+// RunHTTPAndTLS serves httpHandler over TLS on tlsAddr, with the configured TLS mode, and over plain
+// HTTP on httpAddr at the same time, e.g. while clients migrate to HTTPS. Both share one lifecycle:
+// when ctx is canceled or either server fails, both shut down and the first error is returned.
+// OnReady is called for both addresses; Ready, Addr and OnShutdown hooks belong to the TLS server.
+func (server *Server) RunHTTPAndTLS(ctx context.Context, httpAddr, tlsAddr string, httpHandler http.Handler) error {
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	listener, err := server.listen(ctx, server.network(), httpAddr)
+	if err != nil {
+		return fmt.Errorf("server error: %w", err)
+	}
+
+	// The plain server is served once the TLS server has bound its listener and passed the
+	// readiness check, which then covers both.
+	tlsReady := make(chan struct{})
+	ctx = context.WithValue(ctx, serveGateKey{}, sync.OnceFunc(func() {
+		if server.OnReady != nil {
+			server.OnReady(listener.Addr())
 		}
+
+		close(tlsReady)
+	}))
+
+	// The plain server may be hit before the TLS server stores the handler.
+	server.handler.Store(&httpHandler)
+
+	httpServer := server.newHTTPServer(ctx, httpAddr, server.wrapHandler(http.HandlerFunc(server.dispatch)), server.Timeouts)
+	server.applyStreamingMode(httpServer)
+	httpServer.Handler = server.h2cHandler(httpServer.Handler)
+
+	server.mu.Lock()
+	for _, f := range server.shutdownFuncs {
+		httpServer.RegisterOnShutdown(f)
 	}
+	server.mu.Unlock()
 
-	return strings.Join(slices.Collect(prefixIter), ", ")
-}
+	errCh := make(chan error, 1)
 
-// RunManualTLS starts the HTTP server with manually provided TLS certificates.
-func (server *Server) RunManualTLS(ctx context.Context, addr string, httpHandler http.Handler) error {
-	httpServer := &http.Server{
-		Addr:              addr,
-		Handler:           httpHandler,
-		ReadTimeout:       HTTPServerTimeOut,
-		ReadHeaderTimeout: HTTPServerTimeOut,
-		WriteTimeout:      HTTPServerTimeOut,
-		IdleTimeout:       HTTPServerTimeOut,
-		BaseContext:       func(_ net.Listener) context.Context { return ctx },
-		TLSConfig: &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		},
-	}
-
-	err := server.runCancelable(ctx, httpServer, func() error {
-		server.logger().InfoContext(ctx, "starting server", "address", "https://"+addr)
-
-		err := httpServer.ListenAndServeTLS(server.TLS.CertFile, server.TLS.KeyFile)
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			return fmt.Errorf("failed to start TLS server: %w", err)
+	go func() {
+		defer close(errCh)
+
+		err := server.runCancelable(ctx, httpServer, false, func() error {
+			select {
+			case <-tlsReady:
+			case <-ctx.Done():
+				_ = listener.Close()
+
+				return nil
+			}
+
+			address := addressURL(listener.Addr().Network(), listener.Addr().String(), false)
+			server.logLifecycle(ctx, "starting server", "address", address)
+
+			err := httpServer.Serve(listener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("failed to start server on %s: %w", httpAddr, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			errCh <- err
+
+			// The plain server failing brings the TLS server down with it.
+			cancel(err)
 		}
+	}()
+
+	err = server.runTLS(ctx, tlsAddr, httpHandler)
+
+	cancel(errRunStopped)
+
+	httpErr := <-errCh
 
-		return nil
-	})
 	if err != nil {
-		return fmt.Errorf("server error: %w", err)
+		return err
+	}
+
+	if httpErr != nil {
+		return fmt.Errorf("server error: %w", httpErr)
 	}
 
 	return nil
 }
 
-// RunUnsecured starts the HTTP server without TLS.
-func (server *Server) RunUnsecured(ctx context.Context, addr string, httpHandler http.Handler) error {
-	httpServer := &http.Server{
-		Addr:              addr,
-		Handler:           httpHandler,
-		ReadTimeout:       HTTPServerTimeOut,
-		ReadHeaderTimeout: HTTPServerTimeOut,
-		WriteTimeout:      HTTPServerTimeOut,
-		IdleTimeout:       HTTPServerTimeOut,
-		BaseContext:       func(_ net.Listener) context.Context { return ctx },
+// RunListener starts the HTTP server on a caller-supplied listener instead of binding an address.
+// When TLS is enabled, the listener is wrapped with TLS according to the configured mode. The
+// listener is closed by the time RunListener returns, also when it fails before serving.
+func (server *Server) RunListener(ctx context.Context, listener net.Listener, httpHandler http.Handler) error {
+	defer server.markStopped()
+
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	err := server.Validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, stopLifetime := server.withMaxLifetime(ctx)
+	defer stopLifetime()
+
+	listener = server.wrapListener(listener)
+
+	var tlsConfig *tls.Config
+
+	stopAcmeChallengeServer := func() error { return nil }
+
+	if server.TLS.Enabled {
+		server.logger().DebugContext(ctx, "TLS is enabled")
+
+		tlsConfig, err = server.newTLSConfig()
+		if err != nil {
+			return err
+		}
+
+		server.warnLowWriteTimeout(ctx)
+
+		switch server.tlsMode() {
+		case TLSModeAutoCert, TLSModeAutoCertTLSALPN:
+			err = errors.Join(server.validateAutoCert()...)
+			if err != nil {
+				return err
+			}
+
+			err = server.checkAcmeChallengePort(listener.Addr().String())
+			if err != nil {
+				return err
+			}
+
+			autocertManager, err := server.newAutocertManager(ctx)
+			if err != nil {
+				return err
+			}
+
+			stopAcmeChallengeServer, err = server.startAcmeChallengeServer(ctx, autocertManager, listener.Addr().String())
+			if err != nil {
+				return err
+			}
+
+			defer stopAcmeChallengeServer()
+
+			err = server.preObtainCertificates(ctx, autocertManager)
+			if err != nil {
+				return err
+			}
+
+			configureAutocertTLS(tlsConfig, autocertManager)
+		case TLSModeManual:
+			err = server.configureManualTLS(ctx, tlsConfig)
+			if err != nil {
+				return err
+			}
+		default:
+			return &UnsupportedTLSModeError{Mode: server.TLS.Mode}
+		}
+
+		server.startSessionTicketKeyRotation(ctx, tlsConfig)
+	}
+
+	httpServer := server.newPrimaryHTTPServer(ctx, listener.Addr().String(), httpHandler)
+	httpServer.TLSConfig = tlsConfig
+
+	var h3Server *http3Server
+
+	if tlsConfig == nil {
+		httpServer.Handler = server.h2cHandler(httpServer.Handler)
+	} else {
+		h3Server = server.newHTTP3Server(ctx)
 	}
 
-	err := server.runCancelable(ctx, httpServer, func() error {
-		if strings.HasPrefix(addr, ":") {
-			addr = "0.0.0.0" + addr
+	err = server.runCancelable(ctx, httpServer, true, func() error {
+		if tlsConfig != nil {
+			return server.serveTLS(ctx, httpServer, h3Server, listener)
+		}
+
+		err := server.checkReadiness(ctx)
+		if err != nil {
+			_ = listener.Close()
+
+			return err
 		}
 
-		server.logger().InfoContext(ctx, "starting server", "address", "http://"+addr)
+		server.markReady(ctx, listener.Addr())
 
-		err := httpServer.ListenAndServe()
+		address := addressURL(listener.Addr().Network(), listener.Addr().String(), false)
+		server.logLifecycle(ctx, "starting server", "address", address)
+
+		err = httpServer.Serve(listener)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return fmt.Errorf("failed to start server: %w", err)
 		}
 
 		return nil
 	})
+
+	err = errors.Join(err, h3Server.shutdown(), stopAcmeChallengeServer())
 	if err != nil {
 		return fmt.Errorf("server error: %w", err)
 	}
@@ -251,7 +2353,47 @@ func (server *Server) RunUnsecured(ctx context.Context, addr string, httpHandler
 	return nil
 }
 
-func (server *Server) runCancelable(ctx context.Context, httpServer *http.Server, runFunc func() error) error {
+// RunFD starts the HTTP server on an inherited listening socket, e.g. one bound to a privileged
+// port by a parent process before dropping privileges. It serves like RunListener and takes
+// ownership of fd, which is closed by the time RunFD returns.
+func (server *Server) RunFD(ctx context.Context, fd uintptr, httpHandler http.Handler) error {
+	defer server.markStopped()
+
+	file := os.NewFile(fd, "listener")
+
+	err := server.Validate()
+	if err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	// FileListener works on a duplicate of fd, so the file itself is no longer needed.
+	listener, err := net.FileListener(file)
+
+	closeErr := file.Close()
+	if err == nil && closeErr != nil {
+		_ = listener.Close()
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("server error: failed to use file descriptor %d as listener: %w", fd, err)
+	}
+
+	return server.RunListener(ctx, listener, httpHandler)
+}
+
+// runCancelable runs runFunc until it fails or ctx is done, then shuts httpServer down gracefully
+// and waits for runFunc, which must return once httpServer is shut down. Shutdown hooks only run
+// for the primary server, not for auxiliary ones like the ACME challenge server.
+func (server *Server) runCancelable(ctx context.Context, httpServer *http.Server, primary bool, runFunc func() error) error {
+	defer server.untrackConns(httpServer)
+
+	if server.ConfigureHTTPServer != nil {
+		server.ConfigureHTTPServer(httpServer)
+	}
+
 	errCh := make(chan error, 1)
 
 	go func() {
@@ -268,18 +2410,157 @@ func (server *Server) runCancelable(ctx context.Context, httpServer *http.Server
 	case err := <-errCh:
 		return err
 	case <-ctx.Done():
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		cause := context.Cause(ctx)
+
+		if primary {
+			server.mu.Lock()
+			server.stopCause = cause
+			server.mu.Unlock()
+		}
+
+		server.draining.Store(true)
+
+		server.waitPreShutdownDelay(ctx, primary)
+
+		shutdownCtx, cancel := server.shutdownContext(ctx)
 		defer cancel()
 
-		server.logger().InfoContext(shutdownCtx, "shutting down server...", "reason", ctx.Err())
+		server.logLifecycle(shutdownCtx, "shutting down server...", "reason", cause)
+
+		stopDrainReports := func() {}
+
+		if primary {
+			server.logLifecycle(shutdownCtx, "draining in-flight requests", "requests", server.inFlight.Load())
+
+			stopDrainReports = server.reportDrain()
+		}
+
+		start := time.Now()
+		forced := false
 
 		err := httpServer.Shutdown(shutdownCtx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			server.logger().WarnContext(shutdownCtx, "graceful shutdown timed out, closing remaining connections",
+				"connections", server.openConns(httpServer))
+
+			forced = true
+
+			closeErr := httpServer.Close()
+			if closeErr != nil {
+				err = errors.Join(err, fmt.Errorf("error closing server: %w", closeErr))
+			}
+		}
+
+		// Wait for runFunc, which returns once httpServer is shut down, so nothing it does, such as
+		// marking the server ready, happens after the run returns.
+		<-errCh
+
+		stopDrainReports()
+
+		drained := time.Since(start)
+
+		if primary {
+			server.runShutdownHooks(shutdownCtx)
+
+			if server.OnShutdownComplete != nil {
+				server.OnShutdownComplete(drained, forced, err)
+			}
+		}
+
 		if err != nil {
 			return fmt.Errorf("error shutting down server: %w", err)
 		}
 
-		server.logger().InfoContext(shutdownCtx, "server shut down gracefully")
+		server.logLifecycle(shutdownCtx, "server shut down gracefully")
 
 		return nil
 	}
 }
+
+// waitPreShutdownDelay keeps serving for PreShutdownDelay before shutdown starts. The servers of a run
+// see ctx canceled together, so their delays overlap.
+func (server *Server) waitPreShutdownDelay(ctx context.Context, primary bool) {
+	if server.PreShutdownDelay <= 0 || errors.Is(context.Cause(ctx), errRunStopped) {
+		return
+	}
+
+	if primary {
+		server.logLifecycle(ctx, "delaying shutdown while load balancers deregister", "delay", server.PreShutdownDelay)
+	}
+
+	time.Sleep(server.PreShutdownDelay)
+}
+
+// drainReportInterval is how often OnDrain is called while the server shuts down.
+const drainReportInterval = 100 * time.Millisecond
+
+// reportDrain calls OnDrain with the in-flight request count until the returned function is called,
+// which reports the final count once more.
+func (server *Server) reportDrain() func() {
+	if server.OnDrain == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(drainReportInterval)
+		defer ticker.Stop()
+
+		for {
+			server.OnDrain(int(server.inFlight.Load()))
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+
+		server.OnDrain(int(server.inFlight.Load()))
+	}
+}
+
+// errorLog returns the logger for http.Server errors.
+func (server *Server) errorLog() *log.Logger {
+	if server.ErrorLog != nil || server.Logger == nil {
+		return server.ErrorLog
+	}
+
+	return slog.NewLogLogger(server.Logger.Handler(), slog.LevelWarn)
+}
+
+// logLifecycle logs a lifecycle message at LogLevel.
+func (server *Server) logLifecycle(ctx context.Context, msg string, args ...any) {
+	server.logger().Log(ctx, server.LogLevel, msg, args...)
+}
+
+// reportError passes a background error to OnError, if set.
+func (server *Server) reportError(ctx context.Context, err error) {
+	if server.OnError != nil {
+		server.OnError(ctx, err)
+	}
+}
+
+func (server *Server) runShutdownHooks(ctx context.Context) {
+	for _, hook := range server.OnShutdown {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					server.logger().ErrorContext(ctx, "shutdown hook panicked", "panic", r)
+					server.reportError(ctx, fmt.Errorf("shutdown hook panicked: %v", r))
+				}
+			}()
+
+			hook(ctx)
+		}()
+	}
+}
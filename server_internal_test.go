@@ -2,13 +2,75 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
+type capturedRecord struct {
+	ctx    context.Context
+	record slog.Record
+}
+
+// captureHandler is a slog.Handler that keeps every record with its context.
+type captureHandler struct {
+	mu      sync.Mutex
+	records []capturedRecord
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *captureHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, capturedRecord{ctx: ctx, record: record})
+
+	return nil
+}
+
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *captureHandler) WithGroup(string) slog.Handler { return h }
+
+func (h *captureHandler) find(message string) (capturedRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range h.records {
+		if r.record.Message == message {
+			return r, true
+		}
+	}
+
+	return capturedRecord{}, false
+}
+
+func mustAutocertManager(t *testing.T, srv *Server) *autocert.Manager {
+	t.Helper()
+
+	manager, err := srv.newAutocertManager(context.Background())
+	if err != nil {
+		t.Fatalf("failed to create autocert manager: %v", err)
+	}
+
+	return manager
+}
+
 func TestDomainsToHTTPSAddress(t *testing.T) {
 	t.Parallel()
 
@@ -46,13 +108,41 @@ func TestDomainsToHTTPSAddress(t *testing.T) {
 	}
 }
 
+func TestAddressURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		network  string
+		addr     string
+		secure   bool
+		expected string
+	}{
+		{name: "IPv4 host", network: NetworkTCP, addr: "127.0.0.1:8080", expected: "http://127.0.0.1:8080"},
+		{name: "IPv6 host", network: NetworkTCP, addr: "[::]:8080", expected: "http://[::]:8080"},
+		{name: "TLS", network: NetworkTCP, addr: "[::1]:8443", secure: true, expected: "https://[::1]:8443"},
+		{name: "unix socket", network: NetworkUnix, addr: "/tmp/app.sock", expected: "unix:///tmp/app.sock"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			result := addressURL(tt.network, tt.addr, tt.secure)
+			if tt.expected != result {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
 func TestRunCancelable_ReturnsRunFuncError(t *testing.T) {
 	t.Parallel()
 
 	srv := &Server{}
 	expectedErr := errors.New("boom")
 
-	err := srv.runCancelable(context.Background(), &http.Server{}, func() error {
+	err := srv.runCancelable(context.Background(), &http.Server{}, true, func() error {
 		return expectedErr
 	})
 
@@ -61,6 +151,35 @@ func TestRunCancelable_ReturnsRunFuncError(t *testing.T) {
 	}
 }
 
+func TestRunCancelable_WaitsForRunFunc(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	httpServer := &http.Server{}
+	serve := untilShutdown(httpServer)
+
+	var returned atomic.Bool
+
+	err := srv.runCancelable(ctx, httpServer, true, func() error {
+		err := serve()
+
+		returned.Store(true)
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !returned.Load() {
+		t.Error("expected runCancelable to wait for runFunc to return")
+	}
+}
+
 func TestRunCancelable_ShutsDownOnContextCancel(t *testing.T) {
 	t.Parallel()
 
@@ -83,7 +202,7 @@ func TestRunCancelable_ShutsDownOnContextCancel(t *testing.T) {
 		close(done)
 	}()
 
-	err = srv.runCancelable(ctx, httpServer, func() error {
+	err = srv.runCancelable(ctx, httpServer, true, func() error {
 		err := httpServer.Serve(listener)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return err
@@ -97,3 +216,1291 @@ func TestRunCancelable_ShutsDownOnContextCancel(t *testing.T) {
 
 	<-done
 }
+
+func TestNewHTTPServer_Timeouts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		timeouts Timeouts
+		expected Timeouts
+	}{
+		{
+			name:     "defaults",
+			timeouts: Timeouts{},
+			expected: Timeouts{
+				Read:       HTTPServerTimeOut,
+				ReadHeader: HTTPServerTimeOut,
+				Write:      HTTPServerTimeOut,
+				Idle:       HTTPServerTimeOut,
+			},
+		},
+		{
+			name: "overrides",
+			timeouts: Timeouts{
+				Read:       time.Second,
+				ReadHeader: 2 * time.Second,
+				Write:      10 * time.Minute,
+				Idle:       4 * time.Second,
+			},
+			expected: Timeouts{
+				Read:       time.Second,
+				ReadHeader: 2 * time.Second,
+				Write:      10 * time.Minute,
+				Idle:       4 * time.Second,
+			},
+		},
+		{
+			name:     "partial override",
+			timeouts: Timeouts{Write: 5 * time.Minute},
+			expected: Timeouts{
+				Read:       HTTPServerTimeOut,
+				ReadHeader: HTTPServerTimeOut,
+				Write:      5 * time.Minute,
+				Idle:       HTTPServerTimeOut,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{Timeouts: tt.timeouts}
+			httpServer := srv.newHTTPServer(context.Background(), ":0", http.NewServeMux(), srv.Timeouts)
+
+			got := Timeouts{
+				Read:       httpServer.ReadTimeout,
+				ReadHeader: httpServer.ReadHeaderTimeout,
+				Write:      httpServer.WriteTimeout,
+				Idle:       httpServer.IdleTimeout,
+			}
+			if got != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewHTTPServer_SlowlorisProtection(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                string
+		slowlorisProtection bool
+		timeouts            Timeouts
+		expectedReadHeader  time.Duration
+		expectedRead        time.Duration
+	}{
+		{
+			name:               "disabled",
+			expectedReadHeader: HTTPServerTimeOut,
+			expectedRead:       HTTPServerTimeOut,
+		},
+		{
+			name:                "enabled",
+			slowlorisProtection: true,
+			expectedReadHeader:  SlowlorisReadHeaderTimeout,
+			expectedRead:        HTTPServerTimeOut,
+		},
+		{
+			name:                "explicit read header timeout",
+			slowlorisProtection: true,
+			timeouts:            Timeouts{ReadHeader: 3 * time.Second},
+			expectedReadHeader:  3 * time.Second,
+			expectedRead:        HTTPServerTimeOut,
+		},
+		{
+			name:                "independent of read timeout",
+			slowlorisProtection: true,
+			timeouts:            Timeouts{Read: 10 * time.Minute},
+			expectedReadHeader:  SlowlorisReadHeaderTimeout,
+			expectedRead:        10 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{Timeouts: tt.timeouts, SlowlorisProtection: tt.slowlorisProtection}
+			httpServer := srv.newHTTPServer(context.Background(), ":0", http.NewServeMux(), srv.Timeouts)
+
+			if httpServer.ReadHeaderTimeout != tt.expectedReadHeader {
+				t.Errorf("expected read header timeout %v, got %v", tt.expectedReadHeader, httpServer.ReadHeaderTimeout)
+			}
+
+			if httpServer.ReadTimeout != tt.expectedRead {
+				t.Errorf("expected read timeout %v, got %v", tt.expectedRead, httpServer.ReadTimeout)
+			}
+		})
+	}
+}
+
+func TestRunCancelable_UsesShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		shutdownTimeout time.Duration
+		expected        time.Duration
+	}{
+		{
+			name:            "default",
+			shutdownTimeout: 0,
+			expected:        ShutdownTimeout,
+		},
+		{
+			name:            "custom",
+			shutdownTimeout: 30 * time.Second,
+			expected:        30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &captureHandler{}
+			srv := &Server{
+				ShutdownTimeout: tt.shutdownTimeout,
+				Logger:          slog.New(handler),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			httpServer := &http.Server{}
+
+			before := time.Now()
+
+			err := srv.runCancelable(ctx, httpServer, true, untilShutdown(httpServer))
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			record, ok := handler.find("shutting down server...")
+			if !ok {
+				t.Fatal("expected shutdown log record")
+			}
+
+			if _, ok := handler.find("server shut down gracefully"); !ok {
+				t.Error("expected graceful shutdown log record")
+			}
+
+			deadline, ok := record.ctx.Deadline()
+			if !ok {
+				t.Fatal("expected shutdown context to have a deadline")
+			}
+
+			got := deadline.Sub(before)
+			if got < tt.expected || got > tt.expected+time.Second {
+				t.Errorf("expected deadline about %v from now, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAcmeChallengeHandler_RedirectsToHTTPS(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		tlsAddr  string
+		target   string
+		expected string
+	}{
+		{
+			name:     "default HTTPS port",
+			tlsAddr:  ":443",
+			target:   "http://example.com/some/path?q=1&r=2",
+			expected: "https://example.com/some/path?q=1&r=2",
+		},
+		{
+			name:     "custom HTTPS port",
+			tlsAddr:  ":8443",
+			target:   "http://example.com:80/some/path?q=1",
+			expected: "https://example.com:8443/some/path?q=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				TLS: ServerTLS{
+					RedirectHTTP: true,
+					AutoCert:     &ServerTLSAutoCert{CacheDir: t.TempDir(), Domains: []string{"example.com"}, AcceptTOS: true},
+				},
+			}
+
+			handler := srv.newAcmeChallengeHandler(mustAutocertManager(t, srv), tt.tlsAddr)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Errorf("expected status %d, got %d", http.StatusPermanentRedirect, rec.Code)
+			}
+
+			if got := rec.Header().Get("Location"); got != tt.expected {
+				t.Errorf("expected location %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAcmeChallengeAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		challengePort string
+		expected      string
+	}{
+		{
+			name:          "default",
+			challengePort: "",
+			expected:      ":80",
+		},
+		{
+			name:          "custom",
+			challengePort: "8080",
+			expected:      ":8080",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				TLS: ServerTLS{
+					AutoCert: &ServerTLSAutoCert{ChallengePort: tt.challengePort},
+				},
+			}
+
+			if got := srv.acmeChallengeAddr(); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunListener_DisableHTTPChallenge(t *testing.T) {
+	t.Parallel()
+
+	// Holding the challenge port makes a launched challenge server fail to bind and abort the run.
+	challengeListener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to create challenge listener: %v", err)
+	}
+	defer challengeListener.Close()
+
+	_, challengePort, _ := net.SplitHostPort(challengeListener.Addr().String())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+
+	handler := &captureHandler{}
+	srv := &Server{
+		Logger: slog.New(handler),
+		TLS: ServerTLS{
+			Enabled: true,
+			Mode:    TLSModeAutoCert,
+			AutoCert: &ServerTLSAutoCert{
+				CacheDir:             t.TempDir(),
+				Domains:              []string{"example.com"},
+				AcceptTOS:            true,
+				ChallengePort:        challengePort,
+				DisableHTTPChallenge: true,
+			},
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunListener(ctx, listener, http.NewServeMux())
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+
+	if _, ok := handler.find("ACME HTTP challenge server is disabled"); !ok {
+		t.Error("expected disabled challenge server to be logged")
+	}
+}
+
+func TestNewTLSConfig_MinVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		minVersion uint16
+		expected   uint16
+	}{
+		{
+			name:       "default",
+			minVersion: 0,
+			expected:   tls.VersionTLS12,
+		},
+		{
+			name:       "TLS 1.3",
+			minVersion: tls.VersionTLS13,
+			expected:   tls.VersionTLS13,
+		},
+		{
+			name:       "TLS 1.0",
+			minVersion: tls.VersionTLS10,
+			expected:   tls.VersionTLS10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{TLS: ServerTLS{MinVersion: tt.minVersion}}
+
+			tlsConfig, err := srv.newTLSConfig()
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			if tlsConfig.MinVersion != tt.expected {
+				t.Errorf("expected min version %s, got %s", tls.VersionName(tt.expected), tls.VersionName(tlsConfig.MinVersion))
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig_CipherSuitesAndCurves(t *testing.T) {
+	t.Parallel()
+
+	cipherSuites := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+	curves := []tls.CurveID{tls.CurveP384, tls.CurveP256}
+
+	srv := &Server{
+		TLS: ServerTLS{
+			CipherSuites:     cipherSuites,
+			CurvePreferences: curves,
+		},
+	}
+
+	tlsConfig, err := srv.newTLSConfig()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !slices.Equal(tlsConfig.CipherSuites, cipherSuites) {
+		t.Errorf("expected cipher suites %v, got %v", cipherSuites, tlsConfig.CipherSuites)
+	}
+
+	if !slices.Equal(tlsConfig.CurvePreferences, curves) {
+		t.Errorf("expected curve preferences %v, got %v", curves, tlsConfig.CurvePreferences)
+	}
+
+	defaults, err := (&Server{}).newTLSConfig()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if defaults.CipherSuites != nil || defaults.CurvePreferences != nil {
+		t.Error("expected Go defaults when no cipher suites or curves are configured")
+	}
+}
+
+type memoryCache struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, ok := c.data[key]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+
+	return data, nil
+}
+
+func (c *memoryCache) Put(_ context.Context, key string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.data == nil {
+		c.data = make(map[string][]byte)
+	}
+
+	c.data[key] = data
+
+	return nil
+}
+
+func (c *memoryCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.data, key)
+
+	return nil
+}
+
+func TestNewAutocertManager_Cache(t *testing.T) {
+	t.Parallel()
+
+	t.Run("dir cache by default", func(t *testing.T) {
+		t.Parallel()
+
+		cacheDir := t.TempDir()
+		srv := &Server{TLS: ServerTLS{AutoCert: &ServerTLSAutoCert{CacheDir: cacheDir, AcceptTOS: true}}}
+
+		manager := mustAutocertManager(t, srv)
+		if manager.Cache != autocert.DirCache(cacheDir) {
+			t.Errorf("expected dir cache %q, got %#v", cacheDir, manager.Cache)
+		}
+	})
+
+	t.Run("custom cache wins over cache dir", func(t *testing.T) {
+		t.Parallel()
+
+		cache := &memoryCache{}
+		handler := &captureHandler{}
+		srv := &Server{
+			Logger: slog.New(handler),
+			TLS: ServerTLS{
+				AutoCert: &ServerTLSAutoCert{CacheDir: t.TempDir(), Cache: cache, AcceptTOS: true},
+			},
+		}
+
+		manager := mustAutocertManager(t, srv)
+		if manager.Cache != cache {
+			t.Errorf("expected custom cache, got %#v", manager.Cache)
+		}
+
+		if _, ok := handler.find("using custom autocert cache, ignoring cache dir"); !ok {
+			t.Error("expected debug log about the ignored cache dir")
+		}
+	})
+}
+
+func TestNewAutocertManager_RenewBefore(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		renewBefore time.Duration
+	}{
+		{name: "autocert default", renewBefore: 0},
+		{name: "custom", renewBefore: 72 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				TLS: ServerTLS{
+					AutoCert: &ServerTLSAutoCert{CacheDir: t.TempDir(), AcceptTOS: true, RenewBefore: tt.renewBefore},
+				},
+			}
+
+			manager := mustAutocertManager(t, srv)
+			if manager.RenewBefore != tt.renewBefore {
+				t.Errorf("expected %v, got %v", tt.renewBefore, manager.RenewBefore)
+			}
+		})
+	}
+}
+
+func TestDNSRenewalTime(t *testing.T) {
+	t.Parallel()
+
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name        string
+		lifetime    time.Duration
+		renewBefore time.Duration
+		expected    time.Duration
+	}{
+		{name: "default", lifetime: 90 * day, expected: 30 * day},
+		{name: "default for short-lived", lifetime: 6 * day, expected: 2 * day},
+		{name: "custom", lifetime: 90 * day, renewBefore: 45 * day, expected: 45 * day},
+		{name: "custom not shorter than lifetime", lifetime: 6 * day, renewBefore: 6 * day, expected: 2 * day},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				TLS: ServerTLS{
+					AutoCert: &ServerTLSAutoCert{RenewBefore: tt.renewBefore},
+				},
+			}
+
+			notAfter := time.Now().Add(tt.lifetime)
+			leaf := &x509.Certificate{NotBefore: notAfter.Add(-tt.lifetime), NotAfter: notAfter}
+
+			got := leaf.NotAfter.Sub(srv.dnsRenewalTime(leaf))
+			if got != tt.expected {
+				t.Errorf("expected renewal %v before expiry, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewAutocertManager_HostPolicy(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	errUnknownHost := errors.New("unknown host")
+
+	srv := &Server{
+		TLS: ServerTLS{
+			AutoCert: &ServerTLSAutoCert{
+				CacheDir:  t.TempDir(),
+				AcceptTOS: true,
+				HostPolicy: func(_ context.Context, host string) error {
+					calls.Add(1)
+
+					if host != "customer.example.com" {
+						return errUnknownHost
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+
+	manager := mustAutocertManager(t, srv)
+
+	_, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.example.com"})
+	if !errors.Is(err, errUnknownHost) {
+		t.Errorf("expected host policy error, got %v", err)
+	}
+
+	handler := srv.newAcmeChallengeHandler(manager, ":443")
+	req := httptest.NewRequest(http.MethodGet, "http://unknown.example.com/.well-known/acme-challenge/token", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d from challenge handler, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("expected host policy to be invoked 2 times, got %d", got)
+	}
+}
+
+func TestNewAutocertManager_DirectoryURL(t *testing.T) {
+	t.Parallel()
+
+	const stagingURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+	srv := &Server{TLS: ServerTLS{AutoCert: &ServerTLSAutoCert{DirectoryURL: stagingURL, AcceptTOS: true}}}
+
+	manager := mustAutocertManager(t, srv)
+	if manager.Client == nil || manager.Client.DirectoryURL != stagingURL {
+		t.Errorf("expected client with directory URL %q, got %#v", stagingURL, manager.Client)
+	}
+
+	manager = mustAutocertManager(t, &Server{TLS: ServerTLS{AutoCert: &ServerTLSAutoCert{AcceptTOS: true}}})
+	if manager.Client != nil {
+		t.Errorf("expected default client, got %#v", manager.Client)
+	}
+}
+
+func TestNewAutocertManager_TermsOfService(t *testing.T) {
+	t.Parallel()
+
+	t.Run("accept", func(t *testing.T) {
+		t.Parallel()
+
+		manager := mustAutocertManager(t, &Server{TLS: ServerTLS{AutoCert: &ServerTLSAutoCert{AcceptTOS: true}}})
+		if !manager.Prompt("https://example.com/tos") {
+			t.Error("expected terms of service to be accepted")
+		}
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		t.Parallel()
+
+		var gotURL string
+
+		prompt := func(tosURL string) bool {
+			gotURL = tosURL
+
+			return false
+		}
+
+		manager := mustAutocertManager(t, &Server{TLS: ServerTLS{AutoCert: &ServerTLSAutoCert{Prompt: prompt}}})
+		if manager.Prompt("https://example.com/tos") {
+			t.Error("expected custom prompt result")
+		}
+
+		if gotURL != "https://example.com/tos" {
+			t.Errorf("expected prompt to receive the terms URL, got %q", gotURL)
+		}
+	})
+}
+
+func TestRunCancelable_RunsShutdownHooks(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+
+	handler := &captureHandler{}
+	srv := &Server{
+		Logger: slog.New(handler),
+		OnShutdown: []func(context.Context){
+			func(ctx context.Context) {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("expected hook to receive the shutdown context")
+				}
+
+				calls = append(calls, "first")
+			},
+			func(context.Context) {
+				panic("boom")
+			},
+			func(context.Context) {
+				calls = append(calls, "third")
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	httpServer := &http.Server{}
+
+	err := srv.runCancelable(ctx, httpServer, true, untilShutdown(httpServer))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !slices.Equal(calls, []string{"first", "third"}) {
+		t.Errorf("expected hooks to run once in order, got %v", calls)
+	}
+
+	if _, ok := handler.find("shutdown hook panicked"); !ok {
+		t.Error("expected hook panic to be logged")
+	}
+
+	calls = nil
+
+	httpServer = &http.Server{}
+
+	err = srv.runCancelable(ctx, httpServer, false, untilShutdown(httpServer))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if len(calls) != 0 {
+		t.Errorf("expected no hooks for an auxiliary server, got %v", calls)
+	}
+}
+
+func TestRun_ForceClosesConnectionsAfterShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	handler := &captureHandler{}
+	srv := &Server{
+		Host:            "127.0.0.1",
+		Port:            "0",
+		ShutdownTimeout: 50 * time.Millisecond,
+		Logger:          slog.New(handler),
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	defer close(release)
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			close(started)
+			<-release // outlives the shutdown timeout
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	go func() {
+		resp, err := http.Get("http://" + addr.String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected server to force close connections")
+	}
+
+	record, ok := handler.find("graceful shutdown timed out, closing remaining connections")
+	if !ok {
+		t.Fatal("expected force close to be logged")
+	}
+
+	record.record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "connections" && attr.Value.Int64() != 1 {
+			t.Errorf("expected 1 force-closed connection, got %v", attr.Value)
+		}
+
+		return true
+	})
+}
+
+func TestNewHTTPServer_MaxHeaderBytes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		maxHeaderBytes int
+	}{
+		{
+			name:           "default",
+			maxHeaderBytes: 0,
+		},
+		{
+			name:           "raised",
+			maxHeaderBytes: 4 << 20,
+		},
+		{
+			name:           "lowered",
+			maxHeaderBytes: 16 << 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{MaxHeaderBytes: tt.maxHeaderBytes}
+			httpServer := srv.newHTTPServer(context.Background(), ":0", http.NewServeMux(), srv.Timeouts)
+
+			if httpServer.MaxHeaderBytes != tt.maxHeaderBytes {
+				t.Errorf("expected max header bytes %d, got %d", tt.maxHeaderBytes, httpServer.MaxHeaderBytes)
+			}
+		})
+	}
+}
+
+func TestListenConfig_KeepAlivePeriod(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		keepAlivePeriod time.Duration
+	}{
+		{
+			name:            "default",
+			keepAlivePeriod: 0,
+		},
+		{
+			name:            "custom",
+			keepAlivePeriod: 30 * time.Second,
+		},
+		{
+			name:            "disabled",
+			keepAlivePeriod: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{KeepAlivePeriod: tt.keepAlivePeriod}
+
+			if got := srv.listenConfig().KeepAlive; got != tt.keepAlivePeriod {
+				t.Errorf("expected keep-alive period %v, got %v", tt.keepAlivePeriod, got)
+			}
+		})
+	}
+}
+
+func TestListen_UsesListenConfig(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{KeepAlivePeriod: 30 * time.Second}
+
+	listener, err := srv.listen(context.Background(), NetworkTCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	if _, ok := listener.(*net.TCPListener); !ok {
+		t.Errorf("expected *net.TCPListener, got %T", listener)
+	}
+}
+
+func TestListen_ListenConfigControl(t *testing.T) {
+	t.Parallel()
+
+	var controlled atomic.Bool
+
+	srv := &Server{
+		ListenConfig: &net.ListenConfig{
+			KeepAlive: time.Minute,
+			Control: func(_, _ string, _ syscall.RawConn) error {
+				controlled.Store(true)
+
+				return nil
+			},
+		},
+		KeepAlivePeriod: 30 * time.Second,
+	}
+
+	listener, err := srv.listen(context.Background(), NetworkTCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	if !controlled.Load() {
+		t.Error("expected the Control function to be called during bind")
+	}
+
+	if got := srv.listenConfig().KeepAlive; got != 30*time.Second {
+		t.Errorf("expected keep-alive period %v, got %v", 30*time.Second, got)
+	}
+
+	if srv.ListenConfig.KeepAlive != time.Minute {
+		t.Errorf("expected ListenConfig not to be modified, got keep-alive period %v", srv.ListenConfig.KeepAlive)
+	}
+}
+
+func TestTCPNetwork(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		network  string
+		expected string
+	}{
+		{network: "", expected: NetworkTCP},
+		{network: NetworkTCP, expected: NetworkTCP},
+		{network: NetworkTCP4, expected: NetworkTCP4},
+		{network: NetworkTCP6, expected: NetworkTCP6},
+		{network: NetworkUnix, expected: NetworkTCP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{Network: tt.network}
+
+			if got := srv.tcpNetwork(); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRun_AutoCertTLSALPNMode(t *testing.T) {
+	t.Parallel()
+
+	// Holding the challenge port makes a launched challenge server fail to bind and abort the run.
+	challengeListener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to create challenge listener: %v", err)
+	}
+	defer challengeListener.Close()
+
+	_, challengePort, _ := net.SplitHostPort(challengeListener.Addr().String())
+
+	srv := &Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: ServerTLS{
+			Enabled: true,
+			Mode:    TLSModeAutoCertTLSALPN,
+			AutoCert: &ServerTLSAutoCert{
+				CacheDir:      t.TempDir(),
+				Domains:       []string{"example.com"},
+				AcceptTOS:     true,
+				ChallengePort: challengePort,
+			},
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	nextProtos := srv.httpServer.TLSConfig.NextProtos
+	if !slices.Contains(nextProtos, acme.ALPNProto) {
+		t.Errorf("expected %q in next protos, got %v", acme.ALPNProto, nextProtos)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+}
+
+func TestHTTPChallengeDisabled(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                 string
+		mode                 string
+		disableHTTPChallenge bool
+		expected             bool
+	}{
+		{name: "autocert", mode: TLSModeAutoCert, expected: false},
+		{name: "default mode", mode: "", expected: false},
+		{name: "autocert with disabled challenge", mode: TLSModeAutoCert, disableHTTPChallenge: true, expected: true},
+		{name: "tls-alpn only", mode: TLSModeAutoCertTLSALPN, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				TLS: ServerTLS{
+					Mode:     tt.mode,
+					AutoCert: &ServerTLSAutoCert{DisableHTTPChallenge: tt.disableHTTPChallenge},
+				},
+			}
+
+			if got := srv.httpChallengeDisabled(); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOnError_ChallengeServerFailure(t *testing.T) {
+	t.Parallel()
+
+	errs := make(chan error, 1)
+
+	srv := &Server{
+		TLS: ServerTLS{
+			Enabled: true,
+			AutoCert: &ServerTLSAutoCert{
+				CacheDir:  t.TempDir(),
+				Domains:   []string{"example.com"},
+				AcceptTOS: true,
+			},
+		},
+		OnError: func(_ context.Context, err error) {
+			errs <- err
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+
+	// A closed listener makes the challenge server fail right after it starts serving.
+	_ = listener.Close()
+
+	srv.runAcmeChallengeServer(context.Background(), mustAutocertManager(t, srv), ":443", listener)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected %v, got %v", net.ErrClosed, err)
+		}
+	default:
+		t.Fatal("expected OnError to be called")
+	}
+}
+
+func TestStartAcmeChallengeServer_IgnoresListenerOptions(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+
+	srv := &Server{
+		EnableProxyProtocol: true,
+		RequireProxyHeader:  true,
+		MaxConnections:      1,
+		TLS: ServerTLS{
+			Enabled: true,
+			AutoCert: &ServerTLSAutoCert{
+				CacheDir:      t.TempDir(),
+				Domains:       []string{"example.com"},
+				AcceptTOS:     true,
+				ChallengePort: port,
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop, err := srv.startAcmeChallengeServer(ctx, mustAutocertManager(t, srv), "127.0.0.1:443")
+	if err != nil {
+		t.Fatalf("failed to start ACME challenge server: %v", err)
+	}
+
+	defer stop()
+
+	// The CA connects directly, without a PROXY header.
+	resp, err := http.Get("http://127.0.0.1:" + port + "/.well-known/acme-challenge/token")
+	if err != nil {
+		t.Fatalf("expected the challenge server to answer without a PROXY header, got %v", err)
+	}
+
+	_ = resp.Body.Close()
+}
+
+func TestOnError_ShutdownHookPanic(t *testing.T) {
+	t.Parallel()
+
+	var reported []error
+
+	srv := &Server{
+		OnShutdown: []func(context.Context){
+			func(context.Context) {
+				panic("boom")
+			},
+		},
+		OnError: func(_ context.Context, err error) {
+			reported = append(reported, err)
+		},
+	}
+
+	srv.runShutdownHooks(context.Background())
+
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "boom") {
+		t.Errorf("expected hook panic to be reported, got %v", reported)
+	}
+}
+
+func TestNewTLSConfig_BaseConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		base       *tls.Config
+		minVersion uint16
+		expected   uint16
+	}{
+		{
+			name:     "raises lower min version to the floor",
+			base:     &tls.Config{MinVersion: tls.VersionTLS10},
+			expected: tls.VersionTLS12,
+		},
+		{
+			name:     "keeps higher min version",
+			base:     &tls.Config{MinVersion: tls.VersionTLS13},
+			expected: tls.VersionTLS13,
+		},
+		{
+			name:       "explicit min version is the floor",
+			base:       &tls.Config{MinVersion: tls.VersionTLS12},
+			minVersion: tls.VersionTLS13,
+			expected:   tls.VersionTLS13,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.base.SessionTicketsDisabled = true
+
+			srv := &Server{TLS: ServerTLS{Config: tt.base, MinVersion: tt.minVersion}}
+
+			tlsConfig, err := srv.newTLSConfig()
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			if tlsConfig == tt.base {
+				t.Error("expected base config to be cloned")
+			}
+
+			if !tlsConfig.SessionTicketsDisabled {
+				t.Error("expected base config fields to be kept")
+			}
+
+			if tlsConfig.MinVersion != tt.expected {
+				t.Errorf("expected min version %s, got %s", tls.VersionName(tt.expected), tls.VersionName(tlsConfig.MinVersion))
+			}
+		})
+	}
+}
+
+func TestConfigureManualTLS_KeepsBaseConfigCertificates(t *testing.T) {
+	t.Parallel()
+
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &tls.Certificate{}, nil
+	}
+
+	srv := &Server{
+		TLS: ServerTLS{
+			Enabled: true,
+			Mode:    TLSModeManual,
+			Config:  &tls.Config{GetCertificate: getCertificate},
+		},
+	}
+
+	err := srv.Validate()
+	if err != nil {
+		t.Fatalf("expected base config certificates to satisfy validation, got %v", err)
+	}
+
+	tlsConfig, err := srv.newTLSConfig()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = srv.configureManualTLS(context.Background(), tlsConfig)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if srv.certificates.Load() != nil {
+		t.Error("expected manual certificates not to be loaded")
+	}
+
+	if tlsConfig.GetCertificate == nil {
+		t.Fatal("expected GetCertificate to be kept")
+	}
+}
+
+func TestRun_LogLevel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		logLevel slog.Level
+		expected slog.Level
+	}{
+		{name: "default", expected: slog.LevelInfo},
+		{name: "debug", logLevel: slog.LevelDebug, expected: slog.LevelDebug},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &captureHandler{}
+			srv := &Server{
+				Host:     "127.0.0.1",
+				Port:     "0",
+				Logger:   slog.New(handler),
+				LogLevel: tt.logLevel,
+			}
+
+			ready := srv.Ready()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.Run(ctx, http.NewServeMux())
+			}()
+
+			if _, ok := <-ready; !ok {
+				t.Fatalf("server failed to start: %v", <-errCh)
+			}
+
+			cancel()
+
+			err := <-errCh
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			for _, message := range []string{"starting server", "shutting down server...", "server shut down gracefully"} {
+				record, ok := handler.find(message)
+				if !ok {
+					t.Errorf("expected %q to be logged", message)
+
+					continue
+				}
+
+				if record.record.Level != tt.expected {
+					t.Errorf("expected %q at level %v, got %v", message, tt.expected, record.record.Level)
+				}
+			}
+		})
+	}
+}
+
+// untilShutdown returns a runFunc that blocks until httpServer is shut down, like Serve does.
+func untilShutdown(httpServer *http.Server) func() error {
+	stopped := make(chan struct{})
+	httpServer.RegisterOnShutdown(func() { close(stopped) })
+
+	return func() error {
+		<-stopped
+
+		return nil
+	}
+}
@@ -1,15 +1,102 @@
 package server_test
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/nasermirzaei89/server"
+	"golang.org/x/net/http2"
 )
 
+// writeTestCertificate writes a self-signed certificate for hosts into a temporary directory
+// and returns the certificate and key file paths with a pool that trusts the certificate.
+func writeTestCertificate(t *testing.T, hosts ...string) (string, string, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
+
 func TestUnsupportedTLSModeError_Error(t *testing.T) {
 	t.Parallel()
 
@@ -47,6 +134,216 @@ func TestRun_ReturnsUnsupportedTLSModeError(t *testing.T) {
 	}
 }
 
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	autoCert := func() *server.ServerTLSAutoCert {
+		return &server.ServerTLSAutoCert{Domains: []string{"example.com"}, AcceptTOS: true}
+	}
+
+	tests := []struct {
+		name string
+		tls  server.ServerTLS
+		want []error
+	}{
+		{
+			name: "tls disabled",
+			tls:  server.ServerTLS{Mode: "invalid-mode"},
+		},
+		{
+			name: "valid autocert",
+			tls:  server.ServerTLS{Enabled: true, Mode: server.TLSModeAutoCert, AutoCert: autoCert()},
+		},
+		{
+			name: "valid manual",
+			tls:  server.ServerTLS{Enabled: true, Mode: server.TLSModeManual, CertFile: "cert.pem", KeyFile: "key.pem"},
+		},
+		{
+			name: "autocert config missing",
+			tls:  server.ServerTLS{Enabled: true, Mode: server.TLSModeAutoCert},
+			want: []error{server.ErrAutoCertConfigMissing},
+		},
+		{
+			name: "autocert is the default mode",
+			tls:  server.ServerTLS{Enabled: true},
+			want: []error{server.ErrAutoCertConfigMissing},
+		},
+		{
+			name: "autocert without domains and tos",
+			tls: server.ServerTLS{
+				Enabled:  true,
+				Mode:     server.TLSModeAutoCert,
+				AutoCert: &server.ServerTLSAutoCert{},
+			},
+			want: []error{server.ErrNoDomains, server.ErrTOSNotAccepted},
+		},
+		{
+			name: "pre-obtain without HTTP challenge",
+			tls: server.ServerTLS{
+				Enabled:  true,
+				Mode:     server.TLSModeAutoCertTLSALPN,
+				AutoCert: &server.ServerTLSAutoCert{Domains: []string{"example.com"}, AcceptTOS: true, PreObtain: true},
+			},
+			want: []error{server.ErrPreObtainRequiresHTTPChallenge},
+		},
+		{
+			name: "negative renew before",
+			tls: server.ServerTLS{
+				Enabled:  true,
+				Mode:     server.TLSModeAutoCert,
+				AutoCert: &server.ServerTLSAutoCert{Domains: []string{"example.com"}, AcceptTOS: true, RenewBefore: -time.Hour},
+			},
+			want: []error{server.ErrInvalidRenewBefore},
+		},
+		{
+			name: "renew before of an hour",
+			tls: server.ServerTLS{
+				Enabled:  true,
+				Mode:     server.TLSModeAutoCert,
+				AutoCert: &server.ServerTLSAutoCert{Domains: []string{"example.com"}, AcceptTOS: true, RenewBefore: time.Hour},
+			},
+			want: []error{server.ErrInvalidRenewBefore},
+		},
+		{
+			name: "manual without certificates",
+			tls:  server.ServerTLS{Enabled: true, Mode: server.TLSModeManual},
+			want: []error{server.ErrNoCertificates},
+		},
+		{
+			name: "session ticket rotation with custom wrap session",
+			tls: server.ServerTLS{
+				Enabled:                  true,
+				Mode:                     server.TLSModeManual,
+				CertFile:                 "cert.pem",
+				KeyFile:                  "key.pem",
+				SessionTicketKeyRotation: time.Hour,
+				Config: &tls.Config{
+					WrapSession: func(tls.ConnectionState, *tls.SessionState) ([]byte, error) { return nil, nil },
+				},
+			},
+			want: []error{server.ErrSessionTicketRotationConflict},
+		},
+		{
+			name: "manual without key file",
+			tls:  server.ServerTLS{Enabled: true, Mode: server.TLSModeManual, CertFile: "cert.pem"},
+			want: []error{server.ErrIncompleteKeyPair},
+		},
+		{
+			name: "manual without cert file",
+			tls:  server.ServerTLS{Enabled: true, Mode: server.TLSModeManual, KeyFile: "key.pem"},
+			want: []error{server.ErrIncompleteKeyPair},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{TLS: tt.tls}
+
+			err := srv.Validate()
+			if len(tt.want) == 0 && err != nil {
+				t.Errorf("expected nil, got %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !errors.Is(err, want) {
+					t.Errorf("expected %v, got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate_AggregatesModeAndVersionErrors(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled:    true,
+			Mode:       "invalid-mode",
+			MinVersion: 0x0999,
+		},
+	}
+
+	err := srv.Validate()
+
+	var modeErr *server.UnsupportedTLSModeError
+	if !errors.As(err, &modeErr) {
+		t.Errorf("expected UnsupportedTLSModeError, got %v", err)
+	}
+
+	var versionErr *server.UnsupportedTLSVersionError
+	if !errors.As(err, &versionErr) {
+		t.Errorf("expected UnsupportedTLSVersionError, got %v", err)
+	}
+}
+
+func TestValidate_Port(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		port    string
+		invalid bool
+	}{
+		{port: ""},
+		{port: "0"},
+		{port: "8080"},
+		{port: "65535"},
+		{port: "https"},
+		{port: "eighty", invalid: true},
+		{port: "65536", invalid: true},
+		{port: "-1", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.port, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{Port: tt.port}
+
+			err := srv.Validate()
+
+			var portErr *server.InvalidPortError
+			if errors.As(err, &portErr) != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+
+			if tt.invalid && portErr.Port != tt.port {
+				t.Errorf("expected port %q, got %q", tt.port, portErr.Port)
+			}
+		})
+	}
+}
+
+func TestRun_ReturnsInvalidPortError(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "99999"}
+
+	err := srv.Run(context.Background(), http.NewServeMux())
+
+	var portErr *server.InvalidPortError
+	if !errors.As(err, &portErr) {
+		t.Errorf("expected InvalidPortError, got %v", err)
+	}
+}
+
+func TestRun_ReturnsValidationErrorBeforeBinding(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS:  server.ServerTLS{Enabled: true, Mode: server.TLSModeAutoCert},
+	}
+
+	err := srv.Run(context.Background(), http.NewServeMux())
+	if !errors.Is(err, server.ErrAutoCertConfigMissing) {
+		t.Errorf("expected ErrAutoCertConfigMissing, got %v", err)
+	}
+}
+
 func TestRun_SetsDefaultsBeforeStartFailure(t *testing.T) {
 	t.Parallel()
 
@@ -71,3 +368,4163 @@ func TestRun_SetsDefaultsBeforeStartFailure(t *testing.T) {
 		t.Errorf("expected startup failure message, got %v", err)
 	}
 }
+
+func TestRun_ReadyPublishesBoundAddress(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("expected bound address, got closed channel: %v", <-errCh)
+	}
+
+	if srv.Addr() == nil || srv.Addr().String() != addr.String() {
+		t.Errorf("expected Addr() %v, got %v", addr, srv.Addr())
+	}
+
+	resp, err := http.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+
+	_, ok = <-ready
+	if ok {
+		t.Error("expected ready channel to be closed after shutdown")
+	}
+
+	if srv.Addr() != nil {
+		t.Errorf("expected nil Addr() after shutdown, got %v", srv.Addr())
+	}
+}
+
+func TestRun_IPv6Host(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %v", err)
+	}
+
+	_ = listener.Close()
+
+	srv := &server.Server{
+		Host: "::1",
+		Port: "0",
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("expected bound address, got closed channel: %v", <-errCh)
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.Equal(net.IPv6loopback) || tcpAddr.Port == 0 {
+		t.Fatalf("expected an address on [::1], got %v", addr)
+	}
+
+	resp, err := http.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	found := make(chan *server.Server, 1)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			view, _ := server.FromContext(r.Context())
+			found <- view
+
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("expected bound address, got closed channel: %v", <-errCh)
+	}
+
+	resp, err := http.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	view := <-found
+	if view == nil {
+		t.Fatal("expected the server in the request context")
+	}
+
+	if view == srv {
+		t.Error("expected a copy of the server, got the server itself")
+	}
+
+	if view.Host != srv.Host {
+		t.Errorf("expected host %q, got %q", srv.Host, view.Host)
+	}
+
+	_, ok = server.FromContext(context.Background())
+	if ok {
+		t.Error("expected no server outside a request")
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+}
+
+func TestRun_CanceledContextNeverReady(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+	tests := []struct {
+		name string
+		tls  server.ServerTLS
+	}{
+		{name: "unsecured"},
+		{name: "manual TLS", tls: server.ServerTLS{Enabled: true, Mode: server.TLSModeManual, CertFile: certFile, KeyFile: keyFile}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var readyCalls atomic.Int32
+
+			srv := &server.Server{
+				Host: "127.0.0.1",
+				Port: "0",
+				TLS:  tt.tls,
+				OnReady: func(net.Addr) {
+					readyCalls.Add(1)
+				},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			for range 20 {
+				err := srv.Run(ctx, http.NewServeMux())
+				if err != nil {
+					t.Fatalf("expected nil error, got %v", err)
+				}
+
+				if srv.Addr() != nil {
+					t.Fatalf("expected no address after Run returned, got %v", srv.Addr())
+				}
+			}
+
+			time.Sleep(50 * time.Millisecond)
+
+			if got := readyCalls.Load(); got != 0 {
+				t.Errorf("expected OnReady not to be called for a canceled run, got %d calls", got)
+			}
+		})
+	}
+}
+
+func TestRun_RebindsImmediatelyAfterShutdown(t *testing.T) {
+	t.Parallel()
+
+	addr := "127.0.0.1:0"
+
+	for i := range 2 {
+		srv := &server.Server{}
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			t.Fatalf("failed to split address: %v", err)
+		}
+
+		srv.Host, srv.Port = host, port
+
+		ready := srv.Ready()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+
+		go func() {
+			errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+			}))
+		}()
+
+		bound, ok := <-ready
+		if !ok {
+			cancel()
+			t.Fatalf("expected run %d to bind %s, got %v", i+1, addr, <-errCh)
+		}
+
+		addr = bound.String()
+
+		// The idle keep-alive connection is closed by the server on shutdown, leaving it in TIME_WAIT.
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			cancel()
+			t.Fatalf("failed to request server: %v", err)
+		}
+
+		_ = resp.Body.Close()
+
+		cancel()
+
+		err = <-errCh
+		if err != nil {
+			t.Fatalf("expected nil error on shutdown, got %v", err)
+		}
+	}
+}
+
+func TestRun_ReadyClosedOnBindFailure(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Host: "bad host",
+	}
+
+	ready := srv.Ready()
+
+	err := srv.Run(context.Background(), http.NewServeMux())
+	if err == nil {
+		t.Error("expected startup error, got nil")
+	}
+
+	_, ok := <-ready
+	if ok {
+		t.Error("expected ready channel to be closed without a value")
+	}
+}
+
+func TestRunWithSignals_ShutsDownOnSignal(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+	}
+
+	ready := srv.Ready()
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunWithSignals(context.Background(), http.NewServeMux())
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("failed to find current process: %v", err)
+	}
+
+	err = process.Signal(syscall.SIGTERM)
+	if err != nil {
+		t.Fatalf("failed to send signal: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("expected nil error on graceful shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down after signal")
+	}
+
+	var signalErr *server.SignalError
+	if !errors.As(srv.StopCause(), &signalErr) || signalErr.Signal != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM as stop cause, got %v", srv.StopCause())
+	}
+}
+
+func TestRunWithSignals_CanceledParentContext(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunWithSignals(ctx, http.NewServeMux())
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("expected nil error on graceful shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down for canceled parent context")
+	}
+}
+
+func TestRunListener_ServesOnSuppliedListener(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+
+	// An unusable host proves that no address is bound and the supplied listener is used.
+	srv := &server.Server{
+		Host: "bad host",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunListener(ctx, listener, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	resp, err := http.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+}
+
+func TestRunListener_ManualTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunListener(ctx, listener, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	resp, err := client.Get("https://" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.TLS == nil {
+		t.Error("expected a TLS connection")
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+}
+
+func TestRunAutoCert_RejectsChallengePortConflict(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:      t.TempDir(),
+				Domains:       []string{"example.com"},
+				AcceptTOS:     true,
+				ChallengePort: "8443",
+			},
+		},
+	}
+
+	err := srv.RunAutoCert(context.Background(), ":8443", http.NewServeMux())
+	if !errors.Is(err, server.ErrChallengePortConflict) {
+		t.Errorf("expected ErrChallengePortConflict, got %v", err)
+	}
+}
+
+func TestRunManualTLS_ReturnsUnsupportedTLSVersionError(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled:    true,
+			Mode:       server.TLSModeManual,
+			MinVersion: 0x0999,
+		},
+	}
+
+	err := srv.RunManualTLS(context.Background(), "127.0.0.1:0", http.NewServeMux())
+
+	var versionErr *server.UnsupportedTLSVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("expected UnsupportedTLSVersionError, got %v", err)
+	}
+
+	if versionErr.Version != 0x0999 {
+		t.Errorf("expected version %#x, got %#x", 0x0999, versionErr.Version)
+	}
+
+	want := "TLS version 0x0999 is not supported"
+	if versionErr.Error() != want {
+		t.Errorf("expected %q, got %q", want, versionErr.Error())
+	}
+}
+
+func TestRunAutoCert_RequiresTermsOfServiceAcceptance(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled: true,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir: t.TempDir(),
+				Domains:  []string{"example.com"},
+			},
+		},
+	}
+
+	err := srv.RunAutoCert(context.Background(), "127.0.0.1:0", http.NewServeMux())
+	if !errors.Is(err, server.ErrTOSNotAccepted) {
+		t.Errorf("expected ErrTOSNotAccepted, got %v", err)
+	}
+
+	if err != nil && !strings.Contains(err.Error(), "TLS.AutoCert.AcceptTOS") {
+		t.Errorf("expected descriptive error, got %q", err.Error())
+	}
+}
+
+func TestRunManualTLS_ReturnsTLSCertificateError(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+	_, otherKeyFile, _ := writeTestCertificate(t, "127.0.0.1")
+	missingFile := filepath.Join(t.TempDir(), "missing.pem")
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantIs   error
+	}{
+		{
+			name:     "missing certificate file",
+			certFile: missingFile,
+			keyFile:  keyFile,
+			wantIs:   fs.ErrNotExist,
+		},
+		{
+			name:     "missing key file",
+			certFile: certFile,
+			keyFile:  missingFile,
+			wantIs:   fs.ErrNotExist,
+		},
+		{
+			name:     "mismatched key",
+			certFile: certFile,
+			keyFile:  otherKeyFile,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{
+				TLS: server.ServerTLS{
+					Enabled:  true,
+					Mode:     server.TLSModeManual,
+					CertFile: tt.certFile,
+					KeyFile:  tt.keyFile,
+				},
+			}
+
+			err := srv.RunManualTLS(context.Background(), "127.0.0.1:0", http.NewServeMux())
+
+			var certErr *server.TLSCertificateError
+			if !errors.As(err, &certErr) {
+				t.Fatalf("expected TLSCertificateError, got %v", err)
+			}
+
+			if certErr.CertFile != tt.certFile || certErr.KeyFile != tt.keyFile {
+				t.Errorf("expected files %q/%q, got %q/%q", tt.certFile, tt.keyFile, certErr.CertFile, certErr.KeyFile)
+			}
+
+			if certErr.Err == nil {
+				t.Error("expected underlying error, got nil")
+			}
+
+			if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+				t.Errorf("expected %v, got %v", tt.wantIs, err)
+			}
+		})
+	}
+}
+
+func TestRunManualTLS_PreservesBaseConfig(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+			Config:   &tls.Config{NextProtos: []string{"custom/1"}},
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{
+		RootCAs:    pool,
+		NextProtos: []string{"custom/1"},
+	})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if got := conn.ConnectionState().NegotiatedProtocol; got != "custom/1" {
+		t.Errorf("expected negotiated protocol %q, got %q", "custom/1", got)
+	}
+}
+
+func TestRunAutoCert_RequiresAutoCertConfig(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{Enabled: true},
+	}
+
+	err := srv.RunAutoCert(context.Background(), "127.0.0.1:0", http.NewServeMux())
+	if !errors.Is(err, server.ErrAutoCertConfigMissing) {
+		t.Errorf("expected ErrAutoCertConfigMissing, got %v", err)
+	}
+}
+
+func TestRunAutoCert_RequiresDomains(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+
+	t.Cleanup(func() { _ = listener.Close() })
+
+	tests := []struct {
+		name string
+		run  func(srv *server.Server) error
+	}{
+		{
+			name: "RunAutoCert",
+			run: func(srv *server.Server) error {
+				return srv.RunAutoCert(context.Background(), "127.0.0.1:0", http.NewServeMux())
+			},
+		},
+		{
+			name: "RunListener",
+			run: func(srv *server.Server) error {
+				return srv.RunListener(context.Background(), listener, http.NewServeMux())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{
+				TLS: server.ServerTLS{
+					Enabled:  true,
+					AutoCert: &server.ServerTLSAutoCert{},
+				},
+			}
+
+			// All autocert violations are reported, as by Validate.
+			err := tt.run(srv)
+			for _, want := range []error{server.ErrNoDomains, server.ErrTOSNotAccepted} {
+				if !errors.Is(err, want) {
+					t.Errorf("expected %v, got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunListener_RequiresAutoCertConfig(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{Enabled: true},
+	}
+
+	err = srv.RunListener(context.Background(), listener, http.NewServeMux())
+	if !errors.Is(err, server.ErrAutoCertConfigMissing) {
+		t.Errorf("expected ErrAutoCertConfigMissing, got %v", err)
+	}
+}
+
+func TestRunManualTLS_RequiresClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+	clientCertFile, clientKeyFile, _ := writeTestCertificate(t, "client")
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:      true,
+			Mode:         server.TLSModeManual,
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			ClientCAFile: clientCertFile,
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load client certificate: %v", err)
+	}
+
+	newClient := func(certificates ...tls.Certificate) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      pool,
+					Certificates: certificates,
+					MinVersion:   tls.VersionTLS12,
+				},
+			},
+		}
+	}
+
+	_, err = newClient().Get("https://" + addr.String())
+	if err == nil {
+		t.Error("expected request without client certificate to be rejected")
+	}
+
+	resp, err := newClient(clientCert).Get("https://" + addr.String())
+	if err != nil {
+		t.Fatalf("expected request with client certificate to succeed, got %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestRunManualTLS_InvalidClientCAFile(t *testing.T) {
+	t.Parallel()
+
+	invalidFile := filepath.Join(t.TempDir(), "ca.pem")
+
+	err := os.WriteFile(invalidFile, []byte("not a certificate"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+	tests := []struct {
+		name         string
+		clientCAFile string
+		wantErr      string
+	}{
+		{
+			name:         "missing file",
+			clientCAFile: filepath.Join(t.TempDir(), "missing.pem"),
+			wantErr:      "failed to read client CA file",
+		},
+		{
+			name:         "invalid PEM",
+			clientCAFile: invalidFile,
+			wantErr:      "failed to parse client CA file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{
+				TLS: server.ServerTLS{
+					Enabled:      true,
+					Mode:         server.TLSModeManual,
+					CertFile:     certFile,
+					KeyFile:      keyFile,
+					ClientCAFile: tt.clientCAFile,
+				},
+			}
+
+			err := srv.RunManualTLS(context.Background(), "127.0.0.1:0", http.NewServeMux())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRun_ConnState(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		states []http.ConnState
+	)
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			states = append(states, state)
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	resp, err := http.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	cancel()
+	<-errCh
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, want := range []http.ConnState{http.StateNew, http.StateActive} {
+		if !slices.Contains(states, want) {
+			t.Errorf("expected state %v in %v", want, states)
+		}
+	}
+}
+
+func TestRegisterOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+	}
+
+	called := make(chan struct{})
+
+	srv.RegisterOnShutdown(func() {
+		close(called)
+	})
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	cancel()
+
+	err := <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected registered callback to be called during shutdown")
+	}
+}
+
+func TestRunAutoCert_ReturnsChallengeServerBindError(t *testing.T) {
+	t.Parallel()
+
+	challengeListener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to create challenge listener: %v", err)
+	}
+	defer challengeListener.Close()
+
+	_, challengePort, _ := net.SplitHostPort(challengeListener.Addr().String())
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled: true,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:      t.TempDir(),
+				Domains:       []string{"example.com"},
+				AcceptTOS:     true,
+				ChallengePort: challengePort,
+			},
+		},
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunAutoCert(context.Background(), "127.0.0.1:0", http.NewServeMux())
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			t.Errorf("expected address in use error, got %v", err)
+		}
+
+		if err != nil && !strings.Contains(err.Error(), "failed to start ACME challenge server") {
+			t.Errorf("expected challenge server error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected RunAutoCert to fail fast")
+	}
+}
+
+func TestRun_TCP4(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Network: server.NetworkTCP4,
+		Host:    "127.0.0.1",
+		Port:    "0",
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		t.Fatalf("expected *net.TCPAddr, got %T", addr)
+	}
+
+	if tcpAddr.IP.To4() == nil {
+		t.Errorf("expected IPv4 address, got %v", tcpAddr.IP)
+	}
+}
+
+func TestRun_TCP6RejectsIPv4Host(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Network: server.NetworkTCP6,
+		Host:    "127.0.0.1",
+		Port:    "0",
+	}
+
+	err := srv.Run(context.Background(), http.NewServeMux())
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestRun_UnixSocket(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "server.sock")
+
+	// Leave a stale socket file behind, as a crashed process would.
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to create stale socket: %v", err)
+	}
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	srv := &server.Server{
+		Network: server.NetworkUnix,
+		Host:    socketPath,
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("failed to stat socket: %v", err)
+	}
+
+	if info.Mode().Perm() != server.UnixSocketMode {
+		t.Errorf("expected socket mode %v, got %v", server.UnixSocketMode, info.Mode().Perm())
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+
+	if _, err := os.Stat(socketPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected socket file to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestRun_OnReady(t *testing.T) {
+	t.Parallel()
+
+	readyAddr := make(chan net.Addr, 1)
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		OnReady: func(addr net.Addr) {
+			readyAddr <- addr
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	var addr net.Addr
+
+	select {
+	case addr = <-readyAddr:
+	case err := <-errCh:
+		t.Fatalf("server failed to start: %v", err)
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr.Port == 0 || !tcpAddr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("expected bound 127.0.0.1 address with a port, got %v", addr)
+	}
+
+	cancel()
+	<-errCh
+}
+
+func TestRun_OnReadyNotCalledOnBindFailure(t *testing.T) {
+	t.Parallel()
+
+	called := false
+
+	srv := &server.Server{
+		Host: "bad host",
+		OnReady: func(net.Addr) {
+			called = true
+		},
+	}
+
+	err := srv.Run(context.Background(), http.NewServeMux())
+	if err == nil {
+		t.Error("expected startup error, got nil")
+	}
+
+	if called {
+		t.Error("expected OnReady not to be called when binding fails")
+	}
+}
+
+func TestRunManualTLS_SelectsCertificateBySNI(t *testing.T) {
+	t.Parallel()
+
+	certFileA, keyFileA, poolA := writeTestCertificate(t, "a.example.com")
+	certFileB, keyFileB, poolB := writeTestCertificate(t, "b.example.com")
+
+	certB, err := tls.LoadX509KeyPair(certFileB, keyFileB)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:      true,
+			Mode:         server.TLSModeManual,
+			CertFile:     certFileA,
+			KeyFile:      keyFileA,
+			Certificates: []tls.Certificate{certB},
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	tests := []struct {
+		serverName string
+		pool       *x509.CertPool
+	}{
+		{serverName: "a.example.com", pool: poolA},
+		{serverName: "b.example.com", pool: poolB},
+	}
+
+	for _, tt := range tests {
+		conn, err := tls.Dial("tcp", addr.String(), &tls.Config{
+			ServerName: tt.serverName,
+			RootCAs:    tt.pool,
+			MinVersion: tls.VersionTLS12,
+		})
+		if err != nil {
+			t.Errorf("failed to connect with SNI %q: %v", tt.serverName, err)
+
+			continue
+		}
+
+		peer := conn.ConnectionState().PeerCertificates[0]
+		if !slices.Contains(peer.DNSNames, tt.serverName) {
+			t.Errorf("expected certificate for %q, got %v", tt.serverName, peer.DNSNames)
+		}
+
+		_ = conn.Close()
+	}
+}
+
+func TestRunManualTLS_RequiresCertificates(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled: true,
+			Mode:    server.TLSModeManual,
+		},
+	}
+
+	err := srv.RunManualTLS(context.Background(), "127.0.0.1:0", http.NewServeMux())
+	if !errors.Is(err, server.ErrNoCertificates) {
+		t.Errorf("expected ErrNoCertificates, got %v", err)
+	}
+}
+
+func TestReloadCertificates(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	servedSerial := func() *big.Int {
+		t.Helper()
+
+		conn, err := tls.Dial("tcp", addr.String(), &tls.Config{
+			InsecureSkipVerify: true, // only the served certificate is inspected
+			MinVersion:         tls.VersionTLS12,
+		})
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	before := servedSerial()
+
+	// Overwrite the files in place, as an external renewal would.
+	newCertFile, newKeyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+	for src, dst := range map[string]string{newCertFile: certFile, newKeyFile: keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", src, err)
+		}
+
+		err = os.WriteFile(dst, data, 0o600)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", dst, err)
+		}
+	}
+
+	if got := servedSerial(); got.Cmp(before) != 0 {
+		t.Fatal("expected old certificate until reload")
+	}
+
+	err := srv.ReloadCertificates()
+	if err != nil {
+		t.Fatalf("failed to reload certificates: %v", err)
+	}
+
+	if got := servedSerial(); got.Cmp(before) == 0 {
+		t.Error("expected reloaded certificate to be served")
+	}
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:        true,
+			Mode:           server.TLSModeManual,
+			CertFile:       certFile,
+			KeyFile:        keyFile,
+			ReloadOnSIGHUP: true,
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	servedSerial := func() *big.Int {
+		t.Helper()
+
+		conn, err := tls.Dial("tcp", addr.String(), &tls.Config{
+			InsecureSkipVerify: true, // only the served certificate is inspected
+			MinVersion:         tls.VersionTLS12,
+		})
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	before := servedSerial()
+
+	newCertFile, newKeyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+	for src, dst := range map[string]string{newCertFile: certFile, newKeyFile: keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", src, err)
+		}
+
+		err = os.WriteFile(dst, data, 0o600)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", dst, err)
+		}
+	}
+
+	// The server is subscribed once it is ready, so SIGHUP must not terminate the test binary.
+	err := syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if err != nil {
+		t.Fatalf("failed to send SIGHUP: %v", err)
+	}
+
+	// The reload runs in the background.
+	deadline := time.Now().Add(5 * time.Second)
+
+	for servedSerial().Cmp(before) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the reloaded certificate to be served after SIGHUP")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRunUnsecured_H2C(t *testing.T) {
+	t.Parallel()
+
+	type ctxKey struct{}
+
+	srv := &server.Server{
+		Host:      "127.0.0.1",
+		Port:      "0",
+		EnableH2C: true,
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "base"))
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value, _ := r.Context().Value(ctxKey{}).(string)
+			_, _ = w.Write([]byte(value))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 response, got %s", resp.Proto)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if got := string(body); got != "base" {
+		t.Errorf("expected base context value %q, got %q", "base", got)
+	}
+}
+
+func TestRunHTTPRedirect(t *testing.T) {
+	t.Parallel()
+
+	ready := make(chan net.Addr, 1)
+
+	srv := &server.Server{
+		HealthPath: "/healthz",
+		OnReady: func(addr net.Addr) {
+			ready <- addr
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunHTTPRedirect(ctx, "127.0.0.1:0", "example.com")
+	}()
+
+	var addr net.Addr
+
+	select {
+	case addr = <-ready:
+	case err := <-errCh:
+		t.Fatalf("server failed to start: %v", err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get("http://" + addr.String() + "/path?q=1")
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		t.Errorf("expected status %d, got %d", http.StatusPermanentRedirect, resp.StatusCode)
+	}
+
+	want := "https://example.com/path?q=1"
+	if got := resp.Header.Get("Location"); got != want {
+		t.Errorf("expected location %q, got %q", want, got)
+	}
+
+	healthResp, err := client.Get("http://" + addr.String() + "/healthz")
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+	defer healthResp.Body.Close()
+
+	if healthResp.StatusCode != http.StatusPermanentRedirect {
+		t.Errorf("expected the health path to redirect too, got status %d", healthResp.StatusCode)
+	}
+
+	if srv.Addr() != nil {
+		t.Errorf("expected Addr to stay unset, got %v", srv.Addr())
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestRunManualTLS_HTTP2Settings(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+		HTTP2: &http2.Server{MaxConcurrentStreams: 7},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{
+		RootCAs:    pool,
+		NextProtos: []string{http2.NextProtoTLS},
+		MinVersion: tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	if got := conn.ConnectionState().NegotiatedProtocol; got != http2.NextProtoTLS {
+		t.Fatalf("expected negotiated protocol %q, got %q", http2.NextProtoTLS, got)
+	}
+
+	_, err = io.WriteString(conn, http2.ClientPreface)
+	if err != nil {
+		t.Fatalf("failed to write client preface: %v", err)
+	}
+
+	framer := http2.NewFramer(conn, conn)
+
+	err = framer.WriteSettings()
+	if err != nil {
+		t.Fatalf("failed to write settings: %v", err)
+	}
+
+	frame, err := framer.ReadFrame()
+	if err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+
+	settings, ok := frame.(*http2.SettingsFrame)
+	if !ok {
+		t.Fatalf("expected settings frame, got %T", frame)
+	}
+
+	got, ok := settings.Value(http2.SettingMaxConcurrentStreams)
+	if !ok || got != 7 {
+		t.Errorf("expected max concurrent streams 7, got %d (present=%v)", got, ok)
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestRun_ConfigureHTTPServer(t *testing.T) {
+	t.Parallel()
+
+	output := &syncBuffer{}
+	errorLog := log.New(output, "", 0)
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		ConfigureHTTPServer: func(httpServer *http.Server) {
+			httpServer.ErrorLog = errorLog
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.WriteHeader(http.StatusOK) // reported through the server's ErrorLog
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	resp, err := http.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	cancel()
+	<-errCh
+
+	if !strings.Contains(output.String(), "superfluous response.WriteHeader call") {
+		t.Errorf("expected configured ErrorLog to be used, got %q", output.String())
+	}
+}
+
+func TestRun_BaseContext(t *testing.T) {
+	t.Parallel()
+
+	type ctxKey struct{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(ctx, ctxKey{}, "custom")
+		},
+	}
+
+	ready := srv.Ready()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value, _ := r.Context().Value(ctxKey{}).(string)
+			_, _ = w.Write([]byte(value))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	resp, err := http.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if got := string(body); got != "custom" {
+		t.Errorf("expected base context value %q, got %q", "custom", got)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestRun_ConnContext(t *testing.T) {
+	t.Parallel()
+
+	type baseKey struct{}
+
+	type connKey struct{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(ctx, baseKey{}, "base")
+		},
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, conn.RemoteAddr().String())
+		},
+	}
+
+	ready := srv.Ready()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			base, _ := r.Context().Value(baseKey{}).(string)
+			remote, _ := r.Context().Value(connKey{}).(string)
+			_, _ = w.Write([]byte(base + " " + remote))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	var localAddr string
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+				if err == nil {
+					localAddr = conn.LocalAddr().String()
+				}
+
+				return conn, err
+			},
+		},
+	}
+
+	resp, err := client.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	want := "base " + localAddr
+	if got := string(body); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	cancel()
+	<-errCh
+}
+
+func TestRunMulti(t *testing.T) {
+	t.Parallel()
+
+	addrs := make(chan net.Addr, 2)
+
+	srv := &server.Server{
+		OnReady: func(addr net.Addr) {
+			addrs <- addr
+		},
+	}
+
+	shutdowns := make(chan struct{}, 2)
+
+	srv.RegisterOnShutdown(func() {
+		shutdowns <- struct{}{}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunMulti(ctx, map[string]http.Handler{
+			"127.0.0.1:0": http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("app"))
+			}),
+			"localhost:0": http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("metrics"))
+			}),
+		})
+	}()
+
+	var bodies []string
+
+	for range 2 {
+		addr := <-addrs
+
+		resp, err := http.Get("http://" + addr.String())
+		if err != nil {
+			t.Fatalf("failed to request %s: %v", addr, err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		bodies = append(bodies, string(body))
+	}
+
+	slices.Sort(bodies)
+
+	if want := []string{"app", "metrics"}; !slices.Equal(bodies, want) {
+		t.Errorf("expected %v, got %v", want, bodies)
+	}
+
+	cancel()
+
+	err := <-errCh
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	// http.Server runs shutdown callbacks in their own goroutines.
+	for i := range 2 {
+		select {
+		case <-shutdowns:
+		case <-time.After(time.Second):
+			t.Fatalf("expected both servers to shut down, got %d", i)
+		}
+	}
+}
+
+func TestRunMulti_ReturnsBindError(t *testing.T) {
+	t.Parallel()
+
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+
+	srv := &server.Server{}
+
+	err = srv.RunMulti(context.Background(), map[string]http.Handler{
+		"127.0.0.1:0":            http.NewServeMux(),
+		occupied.Addr().String(): http.NewServeMux(),
+	})
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestRunMulti_RejectsTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+	var readyCalls atomic.Int32
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{Enabled: true, Mode: server.TLSModeManual, CertFile: certFile, KeyFile: keyFile},
+		OnReady: func(net.Addr) {
+			readyCalls.Add(1)
+		},
+	}
+
+	err := srv.RunMulti(context.Background(), map[string]http.Handler{
+		"127.0.0.1:0": http.NewServeMux(),
+	})
+	if !errors.Is(err, server.ErrRunMultiTLS) {
+		t.Errorf("expected %v, got %v", server.ErrRunMultiTLS, err)
+	}
+
+	if got := readyCalls.Load(); got != 0 {
+		t.Errorf("expected nothing to be served, got %d OnReady calls", got)
+	}
+}
+
+func TestRun_ProxyProtocol(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		requireProxyHeader bool
+		header             string
+		expectedRemoteIP   string
+		expectRejected     bool
+	}{
+		{
+			name:             "v1 header",
+			header:           "PROXY TCP4 203.0.113.7 127.0.0.1 56324 443\r\n",
+			expectedRemoteIP: "203.0.113.7:56324",
+		},
+		{
+			name:             "no header",
+			expectedRemoteIP: "127.0.0.1:",
+		},
+		{
+			name:               "required header",
+			requireProxyHeader: true,
+			header:             "PROXY TCP4 203.0.113.7 127.0.0.1 56324 443\r\n",
+			expectedRemoteIP:   "203.0.113.7:56324",
+		},
+		{
+			name:               "missing required header",
+			requireProxyHeader: true,
+			expectRejected:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{
+				Host:                "127.0.0.1",
+				Port:                "0",
+				EnableProxyProtocol: true,
+				RequireProxyHeader:  tt.requireProxyHeader,
+			}
+
+			ready := srv.Ready()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte(r.RemoteAddr))
+				}))
+			}()
+
+			addr, ok := <-ready
+			if !ok {
+				t.Fatalf("server failed to start: %v", <-errCh)
+			}
+
+			conn, err := net.Dial("tcp", addr.String())
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+
+			_, err = conn.Write([]byte(tt.header + "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"))
+			if err != nil {
+				t.Fatalf("failed to write request: %v", err)
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if tt.expectRejected {
+				if err == nil {
+					_ = resp.Body.Close()
+					t.Errorf("expected connection to be rejected, got status %d", resp.StatusCode)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+
+			if got := string(body); !strings.HasPrefix(got, tt.expectedRemoteIP) {
+				t.Errorf("expected remote address %q, got %q", tt.expectedRemoteIP, got)
+			}
+		})
+	}
+}
+
+func TestRun_MaxConnections(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{
+		Host:           "127.0.0.1",
+		Port:           "0",
+		MaxConnections: 1,
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	request := func(conn net.Conn) <-chan error {
+		done := make(chan error, 1)
+
+		go func() {
+			_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+			if err != nil {
+				done <- err
+
+				return
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+
+			done <- err
+		}()
+
+		return done
+	}
+
+	first, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer first.Close()
+
+	// The keep-alive connection stays open after its response and holds the only slot.
+	if err := <-request(first); err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+
+	second, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer second.Close()
+
+	done := request(second)
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected second connection to wait, got response (err: %v)", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = first.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("second request failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected second connection to be served after the first closed")
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestRun_OnDrain(t *testing.T) {
+	t.Parallel()
+
+	reports := make(chan int, 100)
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		OnDrain: func(inFlight int) {
+			reports <- inFlight
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			close(entered)
+			<-release
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	respCh := make(chan error, 1)
+
+	go func() {
+		resp, err := http.Get("http://" + addr.String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+
+		respCh <- err
+	}()
+
+	<-entered
+	cancel()
+
+	if got := <-reports; got != 1 {
+		t.Errorf("expected 1 in-flight request when shutdown starts, got %d", got)
+	}
+
+	close(release)
+
+	err := <-errCh
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	if err := <-respCh; err != nil {
+		t.Errorf("expected in-flight request to complete, got %v", err)
+	}
+
+	var last int
+
+	for len(reports) > 0 {
+		last = <-reports
+	}
+
+	if last != 0 {
+		t.Errorf("expected 0 in-flight requests after shutdown, got %d", last)
+	}
+}
+
+func TestSetHandler(t *testing.T) {
+	t.Parallel()
+
+	srv := &server.Server{Host: "127.0.0.1", Port: "0"}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	instance, err := srv.Start(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(entered)
+		<-release
+		_, _ = w.Write([]byte("old"))
+	}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	defer func() {
+		_ = instance.Shutdown(context.Background())
+	}()
+
+	get := func() (string, error) {
+		resp, err := http.Get("http://" + instance.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+
+		return string(body), err
+	}
+
+	type result struct {
+		body string
+		err  error
+	}
+
+	inFlight := make(chan result, 1)
+
+	go func() {
+		body, err := get()
+		inFlight <- result{body, err}
+	}()
+
+	<-entered
+
+	srv.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("new"))
+	}))
+
+	body, err := get()
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	if body != "new" {
+		t.Errorf("expected %q, got %q", "new", body)
+	}
+
+	close(release)
+
+	old := <-inFlight
+	if old.err != nil {
+		t.Fatalf("in-flight request failed: %v", old.err)
+	}
+
+	if old.body != "old" {
+		t.Errorf("expected in-flight request to finish with %q, got %q", "old", old.body)
+	}
+}
+
+func TestRun_ShutdownContext(t *testing.T) {
+	t.Parallel()
+
+	type ctxKey struct{}
+
+	tests := []struct {
+		name                string
+		shutdownBaseContext context.Context
+		expected            string
+	}{
+		{
+			name:     "detached run context",
+			expected: "run",
+		},
+		{
+			name:                "shutdown base context",
+			shutdownBaseContext: context.WithValue(context.Background(), ctxKey{}, "base"),
+			expected:            "base",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := make(chan string, 1)
+
+			srv := &server.Server{
+				Host:                "127.0.0.1",
+				Port:                "0",
+				ShutdownBaseContext: tt.shutdownBaseContext,
+				OnShutdown: []func(context.Context){
+					func(ctx context.Context) {
+						if ctx.Err() != nil {
+							t.Errorf("expected live shutdown context, got %v", ctx.Err())
+						}
+
+						value, _ := ctx.Value(ctxKey{}).(string)
+						got <- value
+					},
+				},
+			}
+
+			ready := srv.Ready()
+
+			ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "run"))
+			defer cancel()
+
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.Run(ctx, http.NewServeMux())
+			}()
+
+			_, ok := <-ready
+			if !ok {
+				t.Fatalf("server failed to start: %v", <-errCh)
+			}
+
+			cancel()
+
+			err := <-errCh
+			if err != nil {
+				t.Errorf("expected nil, got %v", err)
+			}
+
+			if value := <-got; value != tt.expected {
+				t.Errorf("expected shutdown context value %q, got %q", tt.expected, value)
+			}
+		})
+	}
+}
+
+// freeAddr reserves a free local TCP address and releases it for the caller to listen on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve port: %v", err)
+	}
+
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	return addr
+}
+
+// listenerFD opens a TCP listener and returns a duplicate of its descriptor, owned by the caller,
+// as a parent process would pass it to a child.
+func listenerFD(t *testing.T) (uintptr, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("failed to get listener file: %v", err)
+	}
+	defer file.Close()
+
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatalf("failed to duplicate descriptor: %v", err)
+	}
+
+	return uintptr(fd), listener.Addr().String()
+}
+
+func TestRunFD(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	fd, addr := listenerFD(t)
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunFD(ctx, fd, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	resp, err := client.Get("https://" + addr)
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+}
+
+func TestRunFD_ClosesListenerOnEarlyError(t *testing.T) {
+	t.Parallel()
+
+	fd, addr := listenerFD(t)
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: filepath.Join(t.TempDir(), "missing-cert.pem"),
+			KeyFile:  filepath.Join(t.TempDir(), "missing-key.pem"),
+		},
+	}
+
+	err := srv.RunFD(context.Background(), fd, http.NewServeMux())
+
+	var certErr *server.TLSCertificateError
+	if !errors.As(err, &certErr) {
+		t.Fatalf("expected TLSCertificateError, got %v", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err == nil {
+		_ = conn.Close()
+
+		t.Error("expected the inherited socket to be closed")
+	}
+}
+
+func TestRunFD_InvalidDescriptor(t *testing.T) {
+	t.Parallel()
+
+	file, err := os.CreateTemp(t.TempDir(), "not-a-socket")
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatalf("failed to duplicate descriptor: %v", err)
+	}
+
+	srv := &server.Server{}
+
+	ready := srv.Ready()
+
+	err = srv.RunFD(context.Background(), uintptr(fd), http.NewServeMux())
+	if err == nil {
+		t.Fatal("expected error for a descriptor that is not a socket")
+	}
+
+	if _, ok := <-ready; ok {
+		t.Error("expected Ready to be closed without an address")
+	}
+}
+
+func TestRun_OnShutdownComplete(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		block    bool
+		expected bool
+	}{
+		{name: "graceful", block: false, expected: false},
+		{name: "forced", block: true, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			type result struct {
+				d      time.Duration
+				forced bool
+				err    error
+			}
+
+			results := make(chan result, 1)
+
+			shutdownTimeout := 100 * time.Millisecond
+
+			srv := &server.Server{
+				Host:            "127.0.0.1",
+				Port:            "0",
+				ShutdownTimeout: shutdownTimeout,
+				OnShutdownComplete: func(d time.Duration, forced bool, err error) {
+					results <- result{d, forced, err}
+				},
+			}
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+
+			defer close(release)
+
+			ready := srv.Ready()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.Run(ctx, http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+					close(started)
+					<-release
+				}))
+			}()
+
+			addr, ok := <-ready
+			if !ok {
+				t.Fatalf("server failed to start: %v", <-errCh)
+			}
+
+			if tt.block {
+				go func() {
+					resp, err := http.Get("http://" + addr.String())
+					if err == nil {
+						_ = resp.Body.Close()
+					}
+				}()
+
+				<-started
+			}
+
+			cancel()
+
+			runErr := <-errCh
+
+			got := <-results
+
+			if got.forced != tt.expected {
+				t.Errorf("expected forced %v, got %v", tt.expected, got.forced)
+			}
+
+			if tt.expected {
+				if !errors.Is(got.err, context.DeadlineExceeded) || runErr == nil {
+					t.Errorf("expected deadline exceeded error, got %v", got.err)
+				}
+
+				if got.d < shutdownTimeout {
+					t.Errorf("expected duration of at least %v, got %v", shutdownTimeout, got.d)
+				}
+			} else {
+				if got.err != nil {
+					t.Errorf("expected nil, got %v", got.err)
+				}
+
+				if got.d <= 0 || got.d >= shutdownTimeout {
+					t.Errorf("expected duration within %v, got %v", shutdownTimeout, got.d)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_StopCause(t *testing.T) {
+	t.Parallel()
+
+	errMaintenance := errors.New("maintenance")
+
+	tests := []struct {
+		name     string
+		stop     func(ctx context.Context) (context.Context, func())
+		expected error
+	}{
+		{
+			name: "cancel cause",
+			stop: func(ctx context.Context) (context.Context, func()) {
+				ctx, cancel := context.WithCancelCause(ctx)
+
+				return ctx, func() { cancel(errMaintenance) }
+			},
+			expected: errMaintenance,
+		},
+		{
+			name: "cancel",
+			stop: func(ctx context.Context) (context.Context, func()) {
+				return context.WithCancel(ctx)
+			},
+			expected: context.Canceled,
+		},
+		{
+			name: "deadline",
+			stop: func(ctx context.Context) (context.Context, func()) {
+				ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+
+				return ctx, func() {
+					<-ctx.Done()
+					cancel()
+				}
+			},
+			expected: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{
+				Host: "127.0.0.1",
+				Port: "0",
+			}
+
+			ctx, stop := tt.stop(context.Background())
+
+			ready := srv.Ready()
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.Run(ctx, http.NewServeMux())
+			}()
+
+			if _, ok := <-ready; !ok {
+				t.Fatalf("server failed to start: %v", <-errCh)
+			}
+
+			if cause := srv.StopCause(); cause != nil {
+				t.Errorf("expected nil stop cause while running, got %v", cause)
+			}
+
+			stop()
+
+			err := <-errCh
+			if err != nil {
+				t.Errorf("expected nil error on graceful shutdown, got %v", err)
+			}
+
+			if cause := srv.StopCause(); !errors.Is(cause, tt.expected) {
+				t.Errorf("expected stop cause %v, got %v", tt.expected, cause)
+			}
+		})
+	}
+}
+
+func TestRun_ReturnsListenError(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	srv := &server.Server{Host: host, Port: port}
+
+	err = srv.Run(context.Background(), http.NewServeMux())
+
+	var listenErr *server.ListenError
+	if !errors.As(err, &listenErr) {
+		t.Fatalf("expected ListenError, got %v", err)
+	}
+
+	if listenErr.Addr != listener.Addr().String() {
+		t.Errorf("expected address %q, got %q", listener.Addr().String(), listenErr.Addr)
+	}
+
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("expected address in use error, got %v", err)
+	}
+}
+
+func TestRun_DisableKeepAlives(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		disableKeepAlives bool
+		expected          bool
+	}{
+		{name: "default", disableKeepAlives: false, expected: false},
+		{name: "disabled", disableKeepAlives: true, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{
+				Host:              "127.0.0.1",
+				Port:              "0",
+				DisableKeepAlives: tt.disableKeepAlives,
+			}
+
+			instance, err := srv.Start(context.Background(), http.NewServeMux())
+			if err != nil {
+				t.Fatalf("failed to start server: %v", err)
+			}
+
+			defer func() {
+				_ = instance.Shutdown(context.Background())
+			}()
+
+			resp, err := http.Get("http://" + instance.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to request server: %v", err)
+			}
+
+			_ = resp.Body.Close()
+
+			if resp.Close != tt.expected {
+				t.Errorf("expected Connection: close %v, got %v (header %q)", tt.expected, resp.Close, resp.Header.Get("Connection"))
+			}
+		})
+	}
+}
+
+func TestRun_DisableGeneralOptionsHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		disable  bool
+		expected int
+	}{
+		{name: "default", disable: false, expected: http.StatusOK},
+		{name: "disabled", disable: true, expected: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{
+				Host:                         "127.0.0.1",
+				Port:                         "0",
+				DisableGeneralOptionsHandler: tt.disable,
+			}
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodOptions && r.RequestURI == "*" {
+					w.WriteHeader(http.StatusNoContent)
+
+					return
+				}
+
+				w.WriteHeader(http.StatusTeapot)
+			})
+
+			instance, err := srv.Start(context.Background(), handler)
+			if err != nil {
+				t.Fatalf("failed to start server: %v", err)
+			}
+
+			defer func() {
+				_ = instance.Shutdown(context.Background())
+			}()
+
+			conn, err := net.Dial("tcp", instance.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			_, err = io.WriteString(conn, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			if err != nil {
+				t.Fatalf("failed to write request: %v", err)
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRunManualTLS_ErrorLog(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		configure func(srv *server.Server, output io.Writer)
+		expected  string
+	}{
+		{
+			name: "bridged logger",
+			configure: func(srv *server.Server, output io.Writer) {
+				srv.Logger = slog.New(slog.NewTextHandler(output, nil))
+			},
+			expected: "level=WARN msg=\"http: TLS handshake error",
+		},
+		{
+			name: "error log",
+			configure: func(srv *server.Server, output io.Writer) {
+				srv.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+				srv.ErrorLog = log.New(output, "custom: ", 0)
+			},
+			expected: "custom: http: TLS handshake error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+			srv := &server.Server{
+				Host: "127.0.0.1",
+				Port: "0",
+				TLS: server.ServerTLS{
+					Enabled:  true,
+					Mode:     server.TLSModeManual,
+					CertFile: certFile,
+					KeyFile:  keyFile,
+				},
+			}
+
+			output := &syncBuffer{}
+			tt.configure(srv, output)
+
+			instance, err := srv.Start(context.Background(), http.NewServeMux())
+			if err != nil {
+				t.Fatalf("failed to start server: %v", err)
+			}
+
+			defer func() {
+				_ = instance.Shutdown(context.Background())
+			}()
+
+			conn, err := net.Dial("tcp", instance.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+
+			// Plain HTTP on the TLS port fails the handshake.
+			_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			_, _ = io.Copy(io.Discard, conn)
+			_ = conn.Close()
+
+			deadline := time.Now().Add(time.Second)
+			for !strings.Contains(output.String(), tt.expected) {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %q in log output, got %q", tt.expected, output.String())
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestEffectiveTLSMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		tls             server.ServerTLS
+		expectedEnabled bool
+		expectedMode    string
+	}{
+		{
+			name:            "disabled",
+			tls:             server.ServerTLS{Mode: server.TLSModeManual},
+			expectedEnabled: false,
+			expectedMode:    "",
+		},
+		{
+			name:            "default mode",
+			tls:             server.ServerTLS{Enabled: true},
+			expectedEnabled: true,
+			expectedMode:    server.TLSModeAutoCert,
+		},
+		{
+			name:            "autocert",
+			tls:             server.ServerTLS{Enabled: true, Mode: server.TLSModeAutoCert},
+			expectedEnabled: true,
+			expectedMode:    server.TLSModeAutoCert,
+		},
+		{
+			name:            "autocert TLS-ALPN",
+			tls:             server.ServerTLS{Enabled: true, Mode: server.TLSModeAutoCertTLSALPN},
+			expectedEnabled: true,
+			expectedMode:    server.TLSModeAutoCertTLSALPN,
+		},
+		{
+			name:            "manual",
+			tls:             server.ServerTLS{Enabled: true, Mode: server.TLSModeManual},
+			expectedEnabled: true,
+			expectedMode:    server.TLSModeManual,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &server.Server{TLS: tt.tls}
+
+			enabled, mode := srv.EffectiveTLSMode()
+			if enabled != tt.expectedEnabled || mode != tt.expectedMode {
+				t.Errorf("expected (%v, %q), got (%v, %q)", tt.expectedEnabled, tt.expectedMode, enabled, mode)
+			}
+		})
+	}
+}
+
+func TestRunAutoCert_PreObtain(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		unreachable int
+		expectedErr string
+	}{
+		{
+			name:        "CA recovers",
+			unreachable: 2,
+		},
+		{
+			name:        "CA stays unreachable",
+			unreachable: 100,
+			expectedErr: "ACME directory unreachable after 3 attempts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			challengeListener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to reserve challenge port: %v", err)
+			}
+
+			challengeAddr := challengeListener.Addr().String()
+			_, challengePort, _ := net.SplitHostPort(challengeAddr)
+			_ = challengeListener.Close()
+
+			acmeServer := newFakeACME(t)
+			acmeServer.unreachable = tt.unreachable
+			acmeServer.validate = validateHTTP01(challengeAddr)
+
+			srv := &server.Server{
+				Host: "127.0.0.1",
+				Port: "0",
+				TLS: server.ServerTLS{
+					Enabled: true,
+					Mode:    server.TLSModeAutoCert,
+					AutoCert: &server.ServerTLSAutoCert{
+						CacheDir:          t.TempDir(),
+						Domains:           []string{"example.com"},
+						AcceptTOS:         true,
+						DirectoryURL:      acmeServer.directoryURL(),
+						ChallengePort:     challengePort,
+						PreObtain:         true,
+						PreObtainAttempts: 3,
+						PreObtainBackoff:  10 * time.Millisecond,
+					},
+				},
+			}
+
+			ready := srv.Ready()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.Run(ctx, http.NewServeMux())
+			}()
+
+			addr, ok := <-ready
+
+			if tt.expectedErr != "" {
+				if ok {
+					t.Fatal("expected the server not to start")
+				}
+
+				err := <-errCh
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Errorf("expected error containing %q, got %v", tt.expectedErr, err)
+				}
+
+				return
+			}
+
+			if !ok {
+				t.Fatalf("server failed to start: %v", <-errCh)
+			}
+
+			if got := acmeServer.issuedCount(); got != 1 {
+				t.Errorf("expected the certificate to be issued before the server is ready, got %d issued", got)
+			}
+
+			conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: acmeServer.rootCAs(), ServerName: "example.com"})
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+
+			_ = conn.Close()
+
+			cancel()
+
+			err = <-errCh
+			if err != nil {
+				t.Errorf("expected nil, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRun_MaxLifetime(t *testing.T) {
+	t.Parallel()
+
+	output := &syncBuffer{}
+
+	srv := &server.Server{
+		Host:        "127.0.0.1",
+		Port:        "0",
+		MaxLifetime: 100 * time.Millisecond,
+		Logger:      slog.New(slog.NewTextHandler(output, nil)),
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(context.Background(), http.NewServeMux())
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("expected nil error on graceful shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the server to stop after its max lifetime")
+	}
+
+	if cause := srv.StopCause(); !errors.Is(cause, server.ErrMaxLifetimeReached) {
+		t.Errorf("expected stop cause %v, got %v", server.ErrMaxLifetimeReached, cause)
+	}
+
+	if !strings.Contains(output.String(), `reason="max lifetime reached"`) {
+		t.Errorf("expected shutdown reason to be logged, got %q", output.String())
+	}
+}
+
+func TestRunAutoCert_ChallengeTimeouts(t *testing.T) {
+	t.Parallel()
+
+	challengeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve challenge port: %v", err)
+	}
+
+	_, challengePort, _ := net.SplitHostPort(challengeListener.Addr().String())
+	_ = challengeListener.Close()
+
+	challengeTimeouts := server.Timeouts{
+		Read:       2 * time.Second,
+		ReadHeader: time.Second,
+		Write:      3 * time.Second,
+		Idle:       4 * time.Second,
+	}
+
+	type serverTimeouts struct {
+		addr     string
+		timeouts server.Timeouts
+	}
+
+	configured := make(chan serverTimeouts, 2)
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled: true,
+			Mode:    server.TLSModeAutoCert,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:          t.TempDir(),
+				Domains:           []string{"example.com"},
+				AcceptTOS:         true,
+				ChallengePort:     challengePort,
+				ChallengeTimeouts: challengeTimeouts,
+			},
+		},
+		ConfigureHTTPServer: func(httpServer *http.Server) {
+			configured <- serverTimeouts{
+				addr: httpServer.Addr,
+				timeouts: server.Timeouts{
+					Read:       httpServer.ReadTimeout,
+					ReadHeader: httpServer.ReadHeaderTimeout,
+					Write:      httpServer.WriteTimeout,
+					Idle:       httpServer.IdleTimeout,
+				},
+			}
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	defaults := server.Timeouts{
+		Read:       server.HTTPServerTimeOut,
+		ReadHeader: server.HTTPServerTimeOut,
+		Write:      server.HTTPServerTimeOut,
+		Idle:       server.HTTPServerTimeOut,
+	}
+
+	for range 2 {
+		got := <-configured
+
+		expected := defaults
+		if strings.HasSuffix(got.addr, ":"+challengePort) {
+			expected = challengeTimeouts
+		}
+
+		if got.timeouts != expected {
+			t.Errorf("expected timeouts %+v for %s, got %+v", expected, got.addr, got.timeouts)
+		}
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestRun_StreamingMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		timeouts server.Timeouts
+		want     server.Timeouts
+	}{
+		{
+			name: "defaults",
+			want: server.Timeouts{
+				ReadHeader: server.HTTPServerTimeOut,
+				Idle:       server.StreamingIdleTimeout,
+			},
+		},
+		{
+			name: "custom timeouts",
+			timeouts: server.Timeouts{
+				Read:       time.Second,
+				ReadHeader: 2 * time.Second,
+				Write:      3 * time.Second,
+				Idle:       4 * time.Second,
+			},
+			want: server.Timeouts{
+				ReadHeader: 2 * time.Second,
+				Idle:       4 * time.Second,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			configured := make(chan server.Timeouts, 1)
+
+			srv := &server.Server{
+				Host:          "127.0.0.1",
+				Port:          "0",
+				Timeouts:      tt.timeouts,
+				StreamingMode: true,
+				ConfigureHTTPServer: func(httpServer *http.Server) {
+					configured <- server.Timeouts{
+						Read:       httpServer.ReadTimeout,
+						ReadHeader: httpServer.ReadHeaderTimeout,
+						Write:      httpServer.WriteTimeout,
+						Idle:       httpServer.IdleTimeout,
+					}
+				},
+			}
+
+			ready := srv.Ready()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.Run(ctx, http.NewServeMux())
+			}()
+
+			if _, ok := <-ready; !ok {
+				t.Fatalf("server failed to start: %v", <-errCh)
+			}
+
+			got := <-configured
+			if got != tt.want {
+				t.Errorf("expected timeouts %+v, got %+v", tt.want, got)
+			}
+
+			cancel()
+
+			err := <-errCh
+			if err != nil {
+				t.Errorf("expected nil, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRunManualTLS_WarnsLowWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		write    time.Duration
+		expected bool
+	}{
+		{name: "low write timeout", write: time.Second, expected: true},
+		{name: "default write timeout"},
+		{name: "write timeout at threshold", write: server.LowTLSWriteTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+			output := &syncBuffer{}
+
+			srv := &server.Server{
+				Host: "127.0.0.1",
+				Port: "0",
+				TLS: server.ServerTLS{
+					Enabled:  true,
+					Mode:     server.TLSModeManual,
+					CertFile: certFile,
+					KeyFile:  keyFile,
+				},
+				Timeouts: server.Timeouts{Write: tt.write},
+				Logger:   slog.New(slog.NewTextHandler(output, nil)),
+			}
+
+			ready := srv.Ready()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.Run(ctx, http.NewServeMux())
+			}()
+
+			if _, ok := <-ready; !ok {
+				t.Fatalf("server failed to start: %v", <-errCh)
+			}
+
+			cancel()
+
+			err := <-errCh
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			got := strings.Contains(output.String(), `level=WARN msg="write timeout is low for TLS`)
+			if got != tt.expected {
+				t.Errorf("expected warning %v, got %v in %q", tt.expected, got, output.String())
+			}
+		})
+	}
+}
+
+func TestRunHTTPAndTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	readyAddrs := make(chan net.Addr, 2)
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+		OnReady: func(addr net.Addr) {
+			readyAddrs <- addr
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunHTTPAndTLS(ctx, "127.0.0.1:0", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				_, _ = io.WriteString(w, "https")
+
+				return
+			}
+
+			_, _ = io.WriteString(w, "http")
+		}))
+	}()
+
+	tlsAddr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	var httpAddr net.Addr
+
+	for range 2 {
+		addr := <-readyAddrs
+		if addr.String() != tlsAddr.String() {
+			httpAddr = addr
+		}
+	}
+
+	if httpAddr == nil {
+		t.Fatal("expected OnReady to report the HTTP address")
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+	}}
+
+	for url, expected := range map[string]string{
+		"http://" + httpAddr.String(): "http",
+		"https://" + tlsAddr.String(): "https",
+	} {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("failed to request %s: %v", url, err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+
+		if string(body) != expected {
+			t.Errorf("expected body %q from %s, got %q", expected, url, body)
+		}
+	}
+
+	cancel()
+
+	err := <-errCh
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	conn, err := net.Dial("tcp", httpAddr.String())
+	if err == nil {
+		_ = conn.Close()
+
+		t.Errorf("expected HTTP server to be shut down")
+	}
+}
+
+func TestRunHTTPAndTLS_StopsHTTPOnTLSError(t *testing.T) {
+	t.Parallel()
+
+	var readyCalls atomic.Int32
+
+	dir := t.TempDir()
+
+	// Missing certificate files pass Validate and only fail once the TLS server loads them.
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: filepath.Join(dir, "cert.pem"),
+			KeyFile:  filepath.Join(dir, "key.pem"),
+		},
+		OnReady: func(net.Addr) {
+			readyCalls.Add(1)
+		},
+	}
+
+	httpAddr := freeAddr(t)
+
+	err := srv.RunHTTPAndTLS(context.Background(), httpAddr, "127.0.0.1:0", http.NewServeMux())
+
+	var certErr *server.TLSCertificateError
+	if !errors.As(err, &certErr) {
+		t.Fatalf("expected TLSCertificateError, got %v", err)
+	}
+
+	if got := readyCalls.Load(); got != 0 {
+		t.Errorf("expected the HTTP server never to be ready, got %d OnReady calls", got)
+	}
+
+	conn, err := net.Dial("tcp", httpAddr)
+	if err == nil {
+		_ = conn.Close()
+
+		t.Errorf("expected HTTP server to be shut down")
+	}
+}
+
+func TestRunHTTPAndTLS_ReadinessCheck(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _ := writeTestCertificate(t, "127.0.0.1")
+
+	errNotReady := errors.New("database unreachable")
+
+	tests := []struct {
+		name    string
+		failing bool
+	}{
+		{name: "passing", failing: false},
+		{name: "failing", failing: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				checks     atomic.Int32
+				readyCalls atomic.Int32
+			)
+
+			srv := &server.Server{
+				TLS: server.ServerTLS{
+					Enabled:  true,
+					Mode:     server.TLSModeManual,
+					CertFile: certFile,
+					KeyFile:  keyFile,
+				},
+				ReadinessCheck: func(context.Context) error {
+					checks.Add(1)
+
+					if tt.failing {
+						return errNotReady
+					}
+
+					return nil
+				},
+				OnReady: func(net.Addr) {
+					readyCalls.Add(1)
+				},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			httpAddr := freeAddr(t)
+
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- srv.RunHTTPAndTLS(ctx, httpAddr, "127.0.0.1:0", http.NewServeMux())
+			}()
+
+			if !tt.failing {
+				resp, err := http.Get("http://" + httpAddr)
+				for i := 0; err != nil && i < 50; i++ {
+					time.Sleep(20 * time.Millisecond)
+
+					resp, err = http.Get("http://" + httpAddr)
+				}
+
+				if err != nil {
+					t.Fatalf("failed to request the HTTP server: %v", err)
+				}
+
+				_ = resp.Body.Close()
+
+				cancel()
+			}
+
+			err := <-errCh
+			if tt.failing != errors.Is(err, errNotReady) {
+				t.Errorf("expected error %v: %t, got %v", errNotReady, tt.failing, err)
+			}
+
+			if got := checks.Load(); got != 1 {
+				t.Errorf("expected 1 readiness check for both listeners, got %d", got)
+			}
+
+			wantReady := int32(2)
+			if tt.failing {
+				wantReady = 0
+			}
+
+			if got := readyCalls.Load(); got != wantReady {
+				t.Errorf("expected %d OnReady calls, got %d", wantReady, got)
+			}
+		})
+	}
+}
+
+func TestRunHTTPAndTLS_ValidatesBeforeBinding(t *testing.T) {
+	t.Parallel()
+
+	var readyCalls atomic.Int32
+
+	srv := &server.Server{
+		TLS:       server.ServerTLS{Enabled: true, Mode: server.TLSModeManual},
+		RateLimit: &server.RateLimitConfig{},
+		OnReady: func(net.Addr) {
+			readyCalls.Add(1)
+		},
+	}
+
+	err := srv.RunHTTPAndTLS(context.Background(), "127.0.0.1:0", "127.0.0.1:0", http.NewServeMux())
+	for _, want := range []error{server.ErrNoCertificates, server.ErrInvalidRateLimit} {
+		if !errors.Is(err, want) {
+			t.Errorf("expected %v, got %v", want, err)
+		}
+	}
+
+	if got := readyCalls.Load(); got != 0 {
+		t.Errorf("expected nothing to be bound, got %d OnReady calls", got)
+	}
+}
+
+func TestRunAutoCert_WaitsForChallengeServer(t *testing.T) {
+	t.Parallel()
+
+	challengeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve challenge port: %v", err)
+	}
+
+	_, challengePort, _ := net.SplitHostPort(challengeListener.Addr().String())
+	_ = challengeListener.Close()
+
+	started := make(chan struct{})
+
+	var finished atomic.Bool
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled: true,
+			Mode:    server.TLSModeAutoCert,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:      t.TempDir(),
+				Domains:       []string{"example.com"},
+				AcceptTOS:     true,
+				ChallengePort: challengePort,
+			},
+		},
+		ConfigureHTTPServer: func(httpServer *http.Server) {
+			if !strings.HasSuffix(httpServer.Addr, ":"+challengePort) {
+				return
+			}
+
+			httpServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				close(started)
+				time.Sleep(200 * time.Millisecond)
+				finished.Store(true)
+				w.WriteHeader(http.StatusNoContent)
+			})
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	go func() {
+		resp, err := http.Get("http://127.0.0.1:" + challengePort + "/")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+
+	<-started
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if !finished.Load() {
+		t.Error("expected the challenge server to drain its request before Run returned")
+	}
+}
+
+func TestRunAutoCert_StopsChallengeServerOnError(t *testing.T) {
+	t.Parallel()
+
+	challengeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve challenge port: %v", err)
+	}
+
+	challengeAddr := challengeListener.Addr().String()
+	_, challengePort, _ := net.SplitHostPort(challengeAddr)
+	_ = challengeListener.Close()
+
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to occupy TLS port: %v", err)
+	}
+	defer occupied.Close()
+
+	_, tlsPort, _ := net.SplitHostPort(occupied.Addr().String())
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: tlsPort,
+		TLS: server.ServerTLS{
+			Enabled: true,
+			Mode:    server.TLSModeAutoCert,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:      t.TempDir(),
+				Domains:       []string{"example.com"},
+				AcceptTOS:     true,
+				ChallengePort: challengePort,
+			},
+		},
+	}
+
+	err = srv.Run(context.Background(), http.NewServeMux())
+
+	var listenErr *server.ListenError
+	if !errors.As(err, &listenErr) {
+		t.Fatalf("expected *server.ListenError, got %v", err)
+	}
+
+	listener, err := net.Listen("tcp", challengeAddr)
+	if err != nil {
+		t.Fatalf("expected the challenge port to be released, got %v", err)
+	}
+
+	_ = listener.Close()
+}
+
+func TestRunAutoCert_ReleasesChallengePortOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	challengeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve challenge port: %v", err)
+	}
+
+	challengeAddr := challengeListener.Addr().String()
+	_, challengePort, _ := net.SplitHostPort(challengeAddr)
+	_ = challengeListener.Close()
+
+	for range 3 {
+		srv := &server.Server{
+			Host: "127.0.0.1",
+			Port: "0",
+			TLS: server.ServerTLS{
+				Enabled: true,
+				Mode:    server.TLSModeAutoCert,
+				AutoCert: &server.ServerTLSAutoCert{
+					CacheDir:      t.TempDir(),
+					Domains:       []string{"example.com"},
+					AcceptTOS:     true,
+					ChallengePort: challengePort,
+				},
+			},
+		}
+
+		instance, err := srv.Start(context.Background(), http.NewServeMux())
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+
+		err = instance.Shutdown(context.Background())
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		listener, err := net.Listen("tcp", challengeAddr)
+		if err != nil {
+			t.Fatalf("expected the challenge port to be released, got %v", err)
+		}
+
+		_ = listener.Close()
+	}
+}
+
+func TestRunAutoCert_ReturnsChallengeServerShutdownError(t *testing.T) {
+	t.Parallel()
+
+	challengeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve challenge port: %v", err)
+	}
+
+	_, challengePort, _ := net.SplitHostPort(challengeListener.Addr().String())
+	_ = challengeListener.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	defer close(release)
+
+	srv := &server.Server{
+		Host:            "127.0.0.1",
+		Port:            "0",
+		ShutdownTimeout: 50 * time.Millisecond,
+		TLS: server.ServerTLS{
+			Enabled: true,
+			Mode:    server.TLSModeAutoCert,
+			AutoCert: &server.ServerTLSAutoCert{
+				CacheDir:      t.TempDir(),
+				Domains:       []string{"example.com"},
+				AcceptTOS:     true,
+				ChallengePort: challengePort,
+			},
+		},
+		ConfigureHTTPServer: func(httpServer *http.Server) {
+			if !strings.HasSuffix(httpServer.Addr, ":"+challengePort) {
+				return
+			}
+
+			httpServer.Handler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				close(started)
+				<-release
+			})
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	if _, ok := <-ready; !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	go func() {
+		resp, err := http.Get("http://127.0.0.1:" + challengePort + "/")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+
+	<-started
+	cancel()
+
+	err = <-errCh
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRun_ReadinessCheck(t *testing.T) {
+	t.Parallel()
+
+	errNotReady := errors.New("database unreachable")
+
+	t.Run("failing", func(t *testing.T) {
+		t.Parallel()
+
+		var readyCalled atomic.Bool
+
+		srv := &server.Server{
+			Host: "127.0.0.1",
+			Port: "0",
+			ReadinessCheck: func(context.Context) error {
+				return errNotReady
+			},
+			OnReady: func(net.Addr) {
+				readyCalled.Store(true)
+			},
+		}
+
+		ready := srv.Ready()
+
+		err := srv.Run(context.Background(), http.NewServeMux())
+		if !errors.Is(err, errNotReady) {
+			t.Fatalf("expected %v, got %v", errNotReady, err)
+		}
+
+		if _, ok := <-ready; ok {
+			t.Error("expected the server not to become ready")
+		}
+
+		if readyCalled.Load() {
+			t.Error("expected OnReady not to be called")
+		}
+	})
+
+	t.Run("passing", func(t *testing.T) {
+		t.Parallel()
+
+		var checks atomic.Int32
+
+		srv := &server.Server{
+			Host: "127.0.0.1",
+			Port: "0",
+			ReadinessCheck: func(ctx context.Context) error {
+				checks.Add(1)
+
+				return ctx.Err()
+			},
+		}
+
+		instance, err := srv.Start(context.Background(), http.NewServeMux())
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+
+		resp, err := http.Get("http://" + instance.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to request server: %v", err)
+		}
+
+		_ = resp.Body.Close()
+
+		err = instance.Shutdown(context.Background())
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if got := checks.Load(); got != 1 {
+			t.Errorf("expected 1 readiness check, got %d", got)
+		}
+	})
+}
+
+func TestRun_PreShutdownDelay(t *testing.T) {
+	t.Parallel()
+
+	const delay = 300 * time.Millisecond
+
+	srv := &server.Server{
+		Host:             "127.0.0.1",
+		Port:             "0",
+		ReadyPath:        "/readyz",
+		PreShutdownDelay: delay,
+	}
+
+	instance, err := srv.Start(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Err() != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	baseURL := "http://" + instance.Addr().String()
+
+	// Without keep-alives, no spare connection holds up shutdown as a new, not yet idle one.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	get := func(path string) (int, error) {
+		resp, err := client.Get(baseURL + path)
+		if err != nil {
+			return 0, err
+		}
+
+		_ = resp.Body.Close()
+
+		return resp.StatusCode, nil
+	}
+
+	start := time.Now()
+	shutdownErr := make(chan error, 1)
+
+	go func() {
+		shutdownErr <- instance.Shutdown(context.Background())
+	}()
+
+	for {
+		status, err := get("/readyz")
+		if err != nil {
+			t.Fatalf("expected the server to keep serving during the delay, got %v", err)
+		}
+
+		if status == http.StatusServiceUnavailable {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status, err := get("/")
+	if err != nil {
+		t.Fatalf("expected requests to succeed during the delay, got %v", err)
+	}
+
+	if status != http.StatusTeapot {
+		t.Errorf("expected status %d during the delay, got %d", http.StatusTeapot, status)
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("expected shutdown to take at least %v, took %v", delay, elapsed)
+	}
+
+	_, err = get("/")
+	if err == nil {
+		t.Error("expected requests to fail after shutdown")
+	}
+}
+
+func TestRunServer(t *testing.T) {
+	t.Parallel()
+
+	var newConns atomic.Int32
+
+	httpServer := &http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		ReadHeaderTimeout: 7 * time.Second,
+		MaxHeaderBytes:    4096,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				newConns.Add(1)
+			}
+		},
+	}
+
+	srv := &server.Server{
+		Port:       "8080",
+		HealthPath: "/healthz",
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunServer(ctx, httpServer)
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("expected bound address, got closed channel: %v", <-errCh)
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	for path, expected := range map[string]int{"/": http.StatusTeapot, "/healthz": http.StatusOK} {
+		resp, err := client.Get("http://" + addr.String() + path)
+		if err != nil {
+			t.Fatalf("failed to request server: %v", err)
+		}
+
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Errorf("expected status %d for %s, got %d", expected, path, resp.StatusCode)
+		}
+	}
+
+	cancel()
+
+	err := <-errCh
+	if err != nil {
+		t.Fatalf("expected nil error on shutdown, got %v", err)
+	}
+
+	if httpServer.ReadHeaderTimeout != 7*time.Second {
+		t.Errorf("expected read header timeout %v, got %v", 7*time.Second, httpServer.ReadHeaderTimeout)
+	}
+
+	if httpServer.MaxHeaderBytes != 4096 {
+		t.Errorf("expected max header bytes %d, got %d", 4096, httpServer.MaxHeaderBytes)
+	}
+
+	if httpServer.WriteTimeout != server.HTTPServerTimeOut {
+		t.Errorf("expected unset write timeout to default to %v, got %v", server.HTTPServerTimeOut, httpServer.WriteTimeout)
+	}
+
+	if newConns.Load() == 0 {
+		t.Error("expected the custom ConnState to be called")
+	}
+}
+
+func TestRunServer_KeepsTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	tlsConfig := &tls.Config{MaxVersion: tls.VersionTLS12}
+
+	httpServer := &http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		TLSConfig: tlsConfig,
+	}
+
+	srv := &server.Server{
+		TLS: server.ServerTLS{
+			Enabled:  true,
+			Mode:     server.TLSModeManual,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.RunServer(ctx, httpServer)
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("expected bound address, got closed channel: %v", <-errCh)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		DisableKeepAlives: true,
+	}}
+
+	resp, err := client.Get("https://" + addr.String())
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, resp.StatusCode)
+	}
+
+	if resp.TLS.Version != tls.VersionTLS12 {
+		t.Errorf("expected the custom TLSConfig to cap the version at %x, got %x", tls.VersionTLS12, resp.TLS.Version)
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Fatalf("expected nil error on shutdown, got %v", err)
+	}
+
+	if len(tlsConfig.Certificates) != 0 || tlsConfig.GetCertificate != nil {
+		t.Error("expected the custom TLSConfig to be left unchanged")
+	}
+}
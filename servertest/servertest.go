@@ -0,0 +1,41 @@
+// Package servertest starts servers for integration tests, like net/http/httptest but with the
+// lifecycle of the server package.
+package servertest
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/nasermirzaei89/server"
+)
+
+// NewTestServer starts a server created with opts on 127.0.0.1 on a free port and returns its base
+// URL, e.g. "http://127.0.0.1:54321", or "https://..." when opts enable TLS. Host and port options
+// are overridden. The server is shut down gracefully when the test and its subtests finish, and the
+// test fails if it does not start or Run returns an error.
+func NewTestServer(t testing.TB, handler http.Handler, opts ...server.Option) string {
+	t.Helper()
+
+	srv := server.New(slices.Concat(opts, []server.Option{server.WithHost("127.0.0.1"), server.WithPort("0")})...)
+
+	instance, err := srv.Start(context.Background(), handler)
+	if err != nil {
+		t.Fatalf("servertest: failed to start server: %v", err)
+	}
+
+	t.Cleanup(func() {
+		err := instance.Shutdown(context.Background())
+		if err != nil {
+			t.Errorf("servertest: server error: %v", err)
+		}
+	})
+
+	scheme := "http://"
+	if enabled, _ := srv.EffectiveTLSMode(); enabled {
+		scheme = "https://"
+	}
+
+	return scheme + instance.Addr().String()
+}
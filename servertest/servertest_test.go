@@ -0,0 +1,86 @@
+package servertest_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nasermirzaei89/server"
+	"github.com/nasermirzaei89/server/servertest"
+)
+
+func TestNewTestServer(t *testing.T) {
+	t.Parallel()
+
+	baseURL := servertest.NewTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	}))
+
+	if !strings.HasPrefix(baseURL, "http://127.0.0.1:") {
+		t.Errorf("expected base URL on http://127.0.0.1, got %q", baseURL)
+	}
+
+	resp, err := http.Get(baseURL + "/world")
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if string(body) != "hello /world" {
+		t.Errorf("expected body %q, got %q", "hello /world", body)
+	}
+}
+
+func TestNewTestServer_AppliesOptions(t *testing.T) {
+	t.Parallel()
+
+	withHealthPath := func(srv *server.Server) {
+		srv.HealthPath = "/healthz"
+	}
+
+	baseURL := servertest.NewTestServer(t, http.NotFoundHandler(), withHealthPath, server.WithPort("1"))
+
+	resp, err := http.Get(baseURL + "/healthz")
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestNewTestServer_ShutsDownOnCleanup(t *testing.T) {
+	t.Parallel()
+
+	var addr string
+
+	t.Run("serve", func(t *testing.T) {
+		baseURL := servertest.NewTestServer(t, http.NotFoundHandler())
+		addr = strings.TrimPrefix(baseURL, "http://")
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("expected server to accept connections, got %v", err)
+		}
+
+		_ = conn.Close()
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err == nil {
+		_ = conn.Close()
+
+		t.Errorf("expected server at %s to be shut down after cleanup", addr)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"slices"
+	"time"
+)
+
+// minSessionTicketKeys is the number of keys kept by rotation when fewer are configured: the
+// current key and the one before it.
+const minSessionTicketKeys = 2
+
+// startSessionTicketKeyRotation rotates the session ticket keys of tlsConfig in the background
+// until ctx is done, if TLS.SessionTicketKeyRotation is set.
+func (server *Server) startSessionTicketKeyRotation(ctx context.Context, tlsConfig *tls.Config) {
+	if server.TLS.SessionTicketKeyRotation <= 0 {
+		return
+	}
+
+	keys := slices.Clone(server.TLS.SessionTicketKeys)
+	if len(keys) == 0 {
+		keys = [][32]byte{newSessionTicketKey()}
+	}
+
+	// http.Server serves a clone of tlsConfig, so the keys live in a config of their own that the
+	// clone reaches through WrapSession and UnwrapSession.
+	ticketKeys := &tls.Config{}
+	ticketKeys.SetSessionTicketKeys(keys)
+
+	tlsConfig.WrapSession = ticketKeys.EncryptTicket
+	tlsConfig.UnwrapSession = ticketKeys.DecryptTicket
+
+	keep := max(len(server.TLS.SessionTicketKeys), minSessionTicketKeys)
+
+	go func() {
+		ticker := time.NewTicker(server.TLS.SessionTicketKeyRotation)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			keys = rotateSessionTicketKeys(keys, keep)
+			ticketKeys.SetSessionTicketKeys(keys)
+
+			server.logger().DebugContext(ctx, "TLS session ticket keys rotated")
+		}
+	}()
+}
+
+// rotateSessionTicketKeys puts a new key in front of keys and drops the oldest beyond keep.
+func rotateSessionTicketKeys(keys [][32]byte, keep int) [][32]byte {
+	rotated := append([][32]byte{newSessionTicketKey()}, keys...)
+
+	return rotated[:min(len(rotated), keep)]
+}
+
+func newSessionTicketKey() [32]byte {
+	var key [32]byte
+
+	// crypto/rand.Read never returns an error.
+	_, _ = rand.Read(key[:])
+
+	return key
+}
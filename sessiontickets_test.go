@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nasermirzaei89/server"
+)
+
+func runSessionTicketServer(t *testing.T, certFile, keyFile string, keys [][32]byte, rotation time.Duration) string {
+	t.Helper()
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:                  true,
+			Mode:                     server.TLSModeManual,
+			CertFile:                 certFile,
+			KeyFile:                  keyFile,
+			SessionTicketKeys:        keys,
+			SessionTicketKeyRotation: rotation,
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		cancel()
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		<-errCh
+	})
+
+	return addr.String()
+}
+
+// resumes reports whether a request to addr resumed a session from cache. Sessions are cached by
+// server name, so a session from one test server is offered to all others.
+func resumes(t *testing.T, addr string, pool *x509.CertPool, cache tls.ClientSessionCache) bool {
+	t.Helper()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, ClientSessionCache: cache},
+		DisableKeepAlives: true,
+	}}
+
+	resp, err := client.Get("https://" + addr)
+	if err != nil {
+		t.Fatalf("failed to request server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Reading the response processes the session ticket sent after the handshake.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.TLS.DidResume
+}
+
+func TestRunManualTLS_SessionTicketKeys(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	shared := [][32]byte{{1}}
+
+	first := runSessionTicketServer(t, certFile, keyFile, shared, 0)
+	second := runSessionTicketServer(t, certFile, keyFile, shared, 0)
+	other := runSessionTicketServer(t, certFile, keyFile, [][32]byte{{2}}, 0)
+
+	cache := tls.NewLRUClientSessionCache(1)
+
+	if resumes(t, first, pool, cache) {
+		t.Fatal("expected a full handshake without a cached session")
+	}
+
+	if !resumes(t, second, pool, cache) {
+		t.Error("expected the session to resume on a server with the same keys")
+	}
+
+	if resumes(t, other, pool, cache) {
+		t.Error("expected the session not to resume on a server with other keys")
+	}
+}
+
+func TestRunManualTLS_SessionTicketKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	keys := [][32]byte{{1}}
+	rotation := 50 * time.Millisecond
+
+	rotating := runSessionTicketServer(t, certFile, keyFile, keys, rotation)
+	static := runSessionTicketServer(t, certFile, keyFile, keys, 0)
+
+	time.Sleep(3 * rotation)
+
+	cache := tls.NewLRUClientSessionCache(1)
+
+	resumes(t, rotating, pool, cache)
+
+	if !resumes(t, rotating, pool, cache) {
+		t.Error("expected the session to resume on the rotating server")
+	}
+
+	if resumes(t, static, pool, cache) {
+		t.Error("expected rotation to replace the configured key")
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// staticIndex is the file served for directories and, with SPA fallback, for client-side routes.
+const staticIndex = "index.html"
+
+// StaticOption configures a handler created by StaticHandler.
+type StaticOption func(config *staticConfig)
+
+type staticConfig struct {
+	spaFallback  bool
+	cacheControl string
+}
+
+// WithSPAFallback sets whether missing paths without a file extension, such as client-side routes
+// of a single-page app, are answered with the root index.html instead of 404. It is enabled by default.
+func WithSPAFallback(enabled bool) StaticOption {
+	return func(config *staticConfig) {
+		config.spaFallback = enabled
+	}
+}
+
+// WithCacheControl sets the Cache-Control header of served files, e.g. "public, max-age=31536000,
+// immutable" for fingerprinted assets. index.html is sent with "no-cache" instead, so clients pick up
+// new deployments.
+func WithCacheControl(value string) StaticOption {
+	return func(config *staticConfig) {
+		config.cacheControl = value
+	}
+}
+
+// StaticHandler serves the files of fsys, e.g. an embed.FS, with content types, range and
+// conditional requests handled by http.FileServerFS. Directories are served by their index.html and
+// never listed. Paths are cleaned and cannot escape fsys.
+func StaticHandler(fsys fs.FS, opts ...StaticOption) http.Handler {
+	config := &staticConfig{spaFallback: true}
+
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	fileServer := http.FileServerFS(fsys)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+
+		info, err := fs.Stat(fsys, name)
+		if err == nil && info.IsDir() {
+			// Directories are served by their index only, never listed.
+			name = path.Join(name, staticIndex)
+			_, err = fs.Stat(fsys, name)
+		}
+
+		switch {
+		case err == nil:
+			config.setCacheControl(w, name)
+			fileServer.ServeHTTP(w, r)
+		case errors.Is(err, fs.ErrNotExist) && config.spaFallback && path.Ext(name) == "" &&
+			(r.Method == http.MethodGet || r.Method == http.MethodHead):
+			config.setCacheControl(w, staticIndex)
+			http.ServeFileFS(w, r, fsys, staticIndex)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func (config *staticConfig) setCacheControl(w http.ResponseWriter, name string) {
+	if config.cacheControl == "" {
+		return
+	}
+
+	if path.Base(name) == staticIndex {
+		w.Header().Set("Cache-Control", "no-cache")
+
+		return
+	}
+
+	w.Header().Set("Cache-Control", config.cacheControl)
+}
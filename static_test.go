@@ -0,0 +1,131 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nasermirzaei89/server"
+)
+
+func TestStaticHandler(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"index.html":        {Data: []byte("<html>app</html>")},
+		"assets/app.js":     {Data: []byte("console.log('app')")},
+		"assets/style.css":  {Data: []byte("body{}")},
+		"docs/index.html":   {Data: []byte("<html>docs</html>")},
+		"private/notes.txt": {Data: []byte("listed?")},
+	}
+
+	tests := []struct {
+		name                 string
+		opts                 []server.StaticOption
+		path                 string
+		expectedStatus       int
+		expectedBody         string
+		expectedContentType  string
+		expectedCacheControl string
+	}{
+		{
+			name:                "file",
+			path:                "/assets/app.js",
+			expectedStatus:      http.StatusOK,
+			expectedBody:        "console.log('app')",
+			expectedContentType: "text/javascript; charset=utf-8",
+		},
+		{
+			name:                "root index",
+			path:                "/",
+			expectedStatus:      http.StatusOK,
+			expectedBody:        "<html>app</html>",
+			expectedContentType: "text/html; charset=utf-8",
+		},
+		{
+			name:           "directory index",
+			path:           "/docs/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<html>docs</html>",
+		},
+		{
+			name:           "client-side route falls back to index",
+			path:           "/users/42",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<html>app</html>",
+		},
+		{
+			name:           "missing asset",
+			path:           "/assets/missing.js",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "client-side route without fallback",
+			opts:           []server.StaticOption{server.WithSPAFallback(false)},
+			path:           "/users/42",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "directory without index is not listed",
+			opts:           []server.StaticOption{server.WithSPAFallback(false)},
+			path:           "/private/",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "traversal",
+			path:           "/../../etc/passwd",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "encoded traversal",
+			path:           "/assets/..%2f..%2f..%2fetc%2fpasswd",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:                 "cache control",
+			opts:                 []server.StaticOption{server.WithCacheControl("public, max-age=31536000, immutable")},
+			path:                 "/assets/style.css",
+			expectedStatus:       http.StatusOK,
+			expectedBody:         "body{}",
+			expectedCacheControl: "public, max-age=31536000, immutable",
+		},
+		{
+			name:                 "index is revalidated",
+			opts:                 []server.StaticOption{server.WithCacheControl("public, max-age=31536000, immutable")},
+			path:                 "/users/42",
+			expectedStatus:       http.StatusOK,
+			expectedBody:         "<html>app</html>",
+			expectedCacheControl: "no-cache",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := server.StaticHandler(fsys, tt.opts...)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+
+			if tt.expectedBody != "" && rec.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, rec.Body.String())
+			}
+
+			if tt.expectedContentType != "" && rec.Header().Get("Content-Type") != tt.expectedContentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.expectedContentType, rec.Header().Get("Content-Type"))
+			}
+
+			if got := rec.Header().Get("Cache-Control"); got != tt.expectedCacheControl {
+				t.Errorf("expected Cache-Control %q, got %q", tt.expectedCacheControl, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// observeTLSHandshakes reports the failed TLS handshakes of httpServer to OnTLSHandshakeError.
+// A connection closed before its handshake completed failed it: its error is read back from the
+// tls.Conn and matched with the ClientHello recorded through GetConfigForClient. HTTP/3 handshakes
+// are not observed. It must be called once TLSConfig is set and after ConfigureHTTPServer.
+func (server *Server) observeTLSHandshakes(httpServer *http.Server) {
+	if server.OnTLSHandshakeError == nil || httpServer.TLSConfig == nil {
+		return
+	}
+
+	// hellos maps TCP connections in the handshake to their ClientHello.
+	var hellos sync.Map
+
+	tlsConfig := httpServer.TLSConfig
+	getConfigForClient := tlsConfig.GetConfigForClient
+	tlsConfig.GetConfigForClient = func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+		// HTTP/3 shares the config, but its connections never reach ConnState, so skip QUIC.
+		_, quic := info.Conn.RemoteAddr().(*net.UDPAddr)
+		if !quic {
+			hellos.Store(info.Conn, info)
+		}
+
+		if getConfigForClient != nil {
+			return getConfigForClient(info)
+		}
+
+		return nil, nil
+	}
+
+	connState := httpServer.ConnState
+	httpServer.ConnState = func(conn net.Conn, state http.ConnState) {
+		tlsConn, ok := conn.(*tls.Conn)
+
+		// The handshake is over once a connection is active or closed.
+		if ok && state != http.StateNew {
+			value, _ := hellos.LoadAndDelete(tlsConn.NetConn())
+
+			if state == http.StateClosed && !tlsConn.ConnectionState().HandshakeComplete {
+				// Handshake returns the error of the failed handshake instead of starting another.
+				err := tlsConn.Handshake()
+				if err != nil {
+					info, _ := value.(*tls.ClientHelloInfo)
+
+					server.OnTLSHandshakeError(info, err)
+				}
+			}
+		}
+
+		if connState != nil {
+			connState(conn, state)
+		}
+	}
+}
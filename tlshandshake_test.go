@@ -0,0 +1,109 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nasermirzaei89/server"
+)
+
+func TestOnTLSHandshakeError(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCertificate(t, "127.0.0.1")
+
+	type handshakeError struct {
+		info *tls.ClientHelloInfo
+		err  error
+	}
+
+	handshakeErrors := make(chan handshakeError, 1)
+
+	srv := &server.Server{
+		Host: "127.0.0.1",
+		Port: "0",
+		TLS: server.ServerTLS{
+			Enabled:    true,
+			Mode:       server.TLSModeManual,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			MinVersion: tls.VersionTLS13,
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+		OnTLSHandshakeError: func(info *tls.ClientHelloInfo, err error) {
+			handshakeErrors <- handshakeError{info: info, err: err}
+		},
+	}
+
+	ready := srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Run(ctx, http.NewServeMux())
+	}()
+
+	addr, ok := <-ready
+	if !ok {
+		t.Fatalf("server failed to start: %v", <-errCh)
+	}
+
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{
+		RootCAs:    pool,
+		ServerName: "127.0.0.1",
+		MinVersion: tls.VersionTLS12,
+		MaxVersion: tls.VersionTLS12,
+	})
+	if err == nil {
+		_ = conn.Close()
+
+		t.Fatal("expected the handshake to fail")
+	}
+
+	select {
+	case got := <-handshakeErrors:
+		if got.info == nil || !slices.Equal(got.info.SupportedVersions, []uint16{tls.VersionTLS12}) {
+			t.Errorf("expected the client's ClientHello offering TLS 1.2 only, got %+v", got.info)
+		}
+
+		if got.err == nil || !strings.Contains(got.err.Error(), "version") {
+			t.Errorf("expected a version error, got %v", got.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected OnTLSHandshakeError to be called")
+	}
+
+	conn, err = tls.Dial("tcp", addr.String(), &tls.Config{
+		RootCAs:    pool,
+		ServerName: "127.0.0.1",
+		MinVersion: tls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	_ = conn.Close()
+
+	select {
+	case got := <-handshakeErrors:
+		t.Errorf("expected no report for a successful handshake, got %v", got.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error on shutdown, got %v", err)
+	}
+}